@@ -6,26 +6,80 @@ package main
 import (
 	"go/build"
 	"path/filepath"
-	"strings"
 )
 
 type Package struct {
 	*build.Package
 
+	// ModulePath and ModuleVersion identify the module providing the package.
+	// ModuleVersion is empty for main modules.
 	ModulePath    string
 	ModuleVersion string
+
+	// ModuleGoVersion is the go version declared by the module, eg. "1.21".
+	ModuleGoVersion string
+
+	// ImportMap maps import paths appearing in the source files
+	// to the import paths they resolve to, when the two differ
+	// (eg. because the package is vendored).
+	ImportMap map[string]string
 }
 
 type Action struct {
 	Package   Package
 	Objdir    string
 	Importcfg string
+
+	// Deps are the actions building the packages imported by Package.
+	Deps []*Action
+}
+
+// archive returns the path of the package archive built by the action.
+func (a *Action) archive() string {
+	return a.Objdir + "_pkg_.a"
+}
+
+// deps returns the actions building the packages imported by the action.
+// If transitive is set, indirectly imported packages are included as well.
+func (a *Action) deps(transitive bool) []*Action {
+	var deps []*Action
+
+	seen := make(map[*Action]bool)
+	var walk func(ds []*Action)
+	walk = func(ds []*Action) {
+		for _, dep := range ds {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+
+			deps = append(deps, dep)
+			if transitive {
+				walk(dep.Deps)
+			}
+		}
+	}
+	walk(a.Deps)
+
+	return deps
+}
+
+// depArchives returns the archives of the packages imported by the action.
+// If transitive is set, indirectly imported packages are included as well.
+func (a *Action) depArchives(transitive bool) []string {
+	var archives []string
+
+	for _, dep := range a.deps(transitive) {
+		archives = append(archives, dep.archive())
+	}
+
+	return archives
 }
 
 // trimpath returns the -trimpath argument to use
 // when compiling the action.
-func (a *Action) trimpath() string {
-	// Keep in sync with Builder.ccompile
+func (a *Action) trimpath(ctx Context) string {
+	// Keep in sync with cToolchain.prefixMapFlags
 	// The trimmed paths are a little different, but we need to trim in the
 	// same situations.
 
@@ -36,19 +90,8 @@ func (a *Action) trimpath() string {
 	}
 	rewrite := ""
 
-	rewriteDir := a.Package.Dir
-	if true { // TODO: trimpath? // TODO: I didn't have the patience to sort this shit out
-		if m := a.Package.ModulePath; m != "" {
-			if v := a.Package.ModuleVersion; v != "" {
-				rewriteDir = m + "@" + v + strings.TrimPrefix(a.Package.ImportPath, m)
-			} else {
-				rewriteDir = m + strings.TrimPrefix(a.Package.ImportPath, m)
-			}
-		} else {
-			rewriteDir = a.Package.ImportPath
-		}
-
-		rewrite += a.Package.Dir + "=>" + rewriteDir + ";"
+	for _, r := range a.pathRewrites(ctx) {
+		rewrite += r.From + "=>" + r.To + ";"
 	}
 
 	rewrite += objdir + "=>"
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"go/build"
+	"strconv"
+)
+
+// buildContext returns the go/build context selecting the source files
+// of packages built for ctx.
+func (ctx Context) buildContext() (*build.Context, error) {
+	v, err := ctx.goVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	bctx := build.Default
+	bctx.GOROOT = ctx.GOROOT
+	bctx.GOOS = ctx.GOOS
+	bctx.GOARCH = ctx.GOARCH
+	bctx.CgoEnabled = ctx.CgoEnabled
+	bctx.BuildTags = ctx.BuildTags
+
+	// The tool tags of the target rather than of the toolchain gb was built with.
+	experiments, err := ctx.goexperimentTags()
+	if err != nil {
+		return nil, err
+	}
+	bctx.ToolTags = stringList(experiments, ctx.goarchTags())
+
+	bctx.Compiler = ctx.Compiler
+	if bctx.Compiler == "" {
+		bctx.Compiler = "gc"
+	}
+
+	// The release tags of the toolchain rather than of the Go release gb was built with.
+	bctx.ReleaseTags = nil
+	for i := 1; i <= v.Minor; i++ {
+		bctx.ReleaseTags = append(bctx.ReleaseTags, "go1."+strconv.Itoa(i))
+	}
+
+	return &bctx, nil
+}
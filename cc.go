@@ -0,0 +1,283 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// cToolchain runs the C, C++, Objective-C and Fortran compilers
+// building the non-Go sources of a package.
+// It is shared by cgo and the Cc method of the Go toolchains.
+type cToolchain struct {
+	ctx Context
+
+	CC  []string
+	CXX []string
+	FC  []string
+}
+
+// newCToolchain returns the C toolchain configured by ctx.
+// Compilers missing from ctx are read from the environment.
+func newCToolchain(ctx Context) cToolchain {
+	c := cToolchain{
+		ctx: ctx,
+		CC:  strings.Fields(ctx.CC),
+		CXX: strings.Fields(ctx.CXX),
+		FC:  envList("FC", "gfortran"),
+	}
+
+	if len(c.CC) == 0 {
+		c.CC = envList("CC", "gcc")
+	}
+	if len(c.CXX) == 0 {
+		c.CXX = envList("CXX", "g++")
+	}
+
+	return c
+}
+
+// flags returns the flags to use when invoking the C, C++ or Fortran compilers, or cgo.
+// The flags declared by #cgo directives are checked against the allowed flags.
+func (c cToolchain) flags(p Package) (cppflags, cflags, cxxflags, fflags, ldflags []string, err error) {
+	const defaultCFlags = "-O2 -g"
+
+	if cppflags, err = buildFlags("CPPFLAGS", c.ctx.CPPFLAGS, "", p.CgoCPPFLAGS, checkCompilerFlags); err != nil {
+		return
+	}
+	if cflags, err = buildFlags("CFLAGS", c.ctx.CFLAGS, defaultCFlags, p.CgoCFLAGS, checkCompilerFlags); err != nil {
+		return
+	}
+	if cxxflags, err = buildFlags("CXXFLAGS", c.ctx.CXXFLAGS, defaultCFlags, p.CgoCXXFLAGS, checkCompilerFlags); err != nil {
+		return
+	}
+	if fflags, err = buildFlags("FFLAGS", nil, defaultCFlags, p.CgoFFLAGS, checkCompilerFlags); err != nil {
+		return
+	}
+	if ldflags, err = buildFlags("LDFLAGS", nil, defaultCFlags, p.CgoLDFLAGS, checkLinkerFlags); err != nil {
+		return
+	}
+
+	return
+}
+
+// buildFlags checks the flags of a #cgo directive
+// and appends them to flags, or to $CGO_<name> if flags is nil.
+func buildFlags(name string, flags []string, defaults string, fromPackage []string, check func(string, string, []string) error) ([]string, error) {
+	if err := check(name, "#cgo "+name, fromPackage); err != nil {
+		return nil, err
+	}
+	return stringList(ctxList(flags, "CGO_"+name, defaults), fromPackage), nil
+}
+
+// fileFlags returns the flags compiling file, a source file of p.
+func (c cToolchain) fileFlags(p Package, file string) ([]string, error) {
+	cppflags, cflags, cxxflags, fflags, _, err := c.flags(p)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(file) {
+	case ".cc", ".cpp", ".cxx":
+		return stringList(cppflags, cxxflags), nil
+	case ".f", ".F", ".for", ".f90":
+		return stringList(cppflags, fflags), nil
+	default:
+		return stringList(cppflags, cflags), nil
+	}
+}
+
+// compiler returns the compiler for a source file, chosen by its extension.
+func (c cToolchain) compiler(file string) []string {
+	switch filepath.Ext(file) {
+	case ".cc", ".cpp", ".cxx":
+		return c.CXX
+	case ".f", ".F", ".for", ".f90":
+		return c.FC
+	default:
+		return c.CC
+	}
+}
+
+// env returns the environment of the C compilers and cgo.
+func (c cToolchain) env() []string {
+	return []string{"TERM=dumb", "CC=" + strings.Join(c.CC, " ")}
+}
+
+// compile runs the compiler matching the extension of file
+// and creates an object from that single source file.
+func (c cToolchain) compile(exec Executor, a Action, outfile string, flags []string, file string) error {
+	p := a.Package
+	file = mkAbs(p.Dir, file)
+	outfile = mkAbs(p.Dir, outfile)
+
+	files := StepFiles{
+		Inputs:  append(mkAbsFiles(p.Dir, p.HFiles), file),
+		Outputs: []string{outfile},
+	}
+
+	compiler := c.compiler(file)
+
+	return exec.Run(a, files, c.env(), c.compilerCmd(compiler, p.Dir), c.prefixMapFlags(compiler, a), flags, "-o", outfile, "-c", file)
+}
+
+// link runs the C linker to create an executable from a set of object files.
+func (c cToolchain) link(exec Executor, a Action, outfile string, flags []string, objs []string) error {
+	p := a.Package
+	var cmd []string
+	if len(p.CXXFiles) > 0 || len(p.SwigCXXFiles) > 0 {
+		cmd = c.compilerCmd(c.CXX, p.Dir)
+	} else {
+		cmd = c.compilerCmd(c.CC, p.Dir)
+	}
+
+	files := StepFiles{
+		Inputs:  objs,
+		Outputs: []string{outfile},
+	}
+
+	return exec.Run(a, files, c.env(), cmd, "-o", outfile, objs, flags)
+}
+
+// compilerCmd returns a command line prefix for the given compiler.
+func (c cToolchain) compilerCmd(compiler []string, incdir string) []string {
+	a := append(compiler[:len(compiler):len(compiler)], "-I", incdir)
+
+	// Definitely want -fPIC but on Windows gcc complains
+	// "-fPIC ignored for target (all code is position independent)"
+	if c.ctx.GOOS != "windows" {
+		a = append(a, "-fPIC")
+	}
+	a = append(a, gccArchArgs(c.ctx)...)
+	// gcc-4.5 and beyond require explicit "-pthread" flag
+	// for multithreading with pthread library.
+	a = append(a, "-pthread")
+
+	// disable word wrapping in error messages
+	a = append(a, "-fmessage-length=0")
+
+	// On OS X, some of the compilers behave as if -fno-common
+	// is always set, and the Mach-O linker in 6l/8l assumes this.
+	// See https://golang.org/issue/3253.
+	if c.ctx.GOOS == "darwin" || c.ctx.GOOS == "ios" {
+		a = append(a, "-fno-common")
+	}
+
+	return a
+}
+
+var (
+	flagCacheMu sync.Mutex
+	flagCache   = make(map[[2]string]bool)
+)
+
+// supportsFlag checks to see if the compiler supports a flag.
+func (c cToolchain) supportsFlag(compiler []string, flag string) bool {
+	key := [2]string{strings.Join(compiler, " "), flag}
+
+	flagCacheMu.Lock()
+	defer flagCacheMu.Unlock()
+
+	if b, ok := flagCache[key]; ok {
+		return b
+	}
+
+	tmp := os.DevNull
+	if runtime.GOOS == "windows" || runtime.GOOS == "ios" {
+		f, err := ioutil.TempFile("", "")
+		if err != nil {
+			return false
+		}
+		f.Close()
+		tmp = f.Name()
+		defer os.Remove(tmp)
+	}
+
+	cmdArgs := stringList(compiler, flag)
+	if strings.HasPrefix(flag, "-Wl,") /* linker flag */ {
+		cmdArgs = append(cmdArgs, envList("CGO_LDFLAGS", "-O2 -g")...)
+	} else { /* compiler flag, add "-c" */
+		cmdArgs = append(cmdArgs, ctxList(c.ctx.CFLAGS, "CGO_CFLAGS", "-O2 -g")...)
+		cmdArgs = append(cmdArgs, "-c")
+	}
+
+	cmdArgs = append(cmdArgs, "-x", "c", "-", "-o", tmp)
+
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, _ := cmd.CombinedOutput()
+	// GCC says "unrecognized command line option".
+	// clang says "unknown argument".
+	// tcc says "unsupported"
+	// AIX says "not recognized"
+	// Older versions of GCC say "unrecognised debug output level".
+	// For -fsplit-stack GCC says "'-fsplit-stack' is not supported".
+	supported := !bytes.Contains(out, []byte("unrecognized")) &&
+		!bytes.Contains(out, []byte("unknown")) &&
+		!bytes.Contains(out, []byte("unrecognised")) &&
+		!bytes.Contains(out, []byte("is not supported")) &&
+		!bytes.Contains(out, []byte("not recognized")) &&
+		!bytes.Contains(out, []byte("unsupported"))
+
+	flagCache[key] = supported
+
+	return supported
+}
+
+// gccArchArgs returns arguments to pass to gcc based on the architecture.
+func gccArchArgs(ctx Context) []string {
+	switch ctx.GOARCH {
+	case "386":
+		return []string{"-m32"}
+	case "amd64":
+		if ctx.GOOS == "darwin" {
+			return []string{"-arch", "x86_64", "-m64"}
+		}
+		return []string{"-m64"}
+	case "arm64":
+		if ctx.GOOS == "darwin" {
+			return []string{"-arch", "arm64"}
+		}
+	case "arm":
+		return []string{"-marm"} // not thumb
+	case "s390x":
+		return []string{"-m64", "-march=z196"}
+	case "mips64", "mips64le":
+		return []string{"-mabi=64"}
+	case "mips", "mipsle":
+		return []string{"-mabi=32", "-march=mips32"}
+	case "ppc64":
+		if ctx.GOOS == "aix" {
+			return []string{"-maix64"}
+		}
+	}
+	return nil
+}
+
+// ctxList returns flags if not nil, the fields of the environment variable otherwise.
+func ctxList(flags []string, key, def string) []string {
+	if flags != nil {
+		return flags
+	}
+
+	return envList(key, def)
+}
+
+// envList returns the value of the given environment variable broken
+// into fields, using the default value when the variable is empty.
+func envList(key, def string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		v = def
+	}
+	return strings.Fields(v)
+}
@@ -0,0 +1,447 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgo runs cgo on cgofiles and compiles the generated C code and the C, C++,
+// Objective-C and Fortran files of the package.
+// It returns the Go files and object files to add to the package.
+//
+// The C code generated for gccgo is compiled by the Cc method of t.
+func cgo(ctx Context, exec Executor, t Toolchain, a Action, objdir string, pcCFLAGS, pcLDFLAGS, cgofiles, gccfiles, gxxfiles, mfiles, ffiles []string) (outGo, outObj []string, err error) {
+	p := a.Package
+	cc := newCToolchain(ctx)
+	cgoCPPFLAGS, cgoCFLAGS, cgoCXXFLAGS, cgoFFLAGS, cgoLDFLAGS, err := cc.flags(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cgoCPPFLAGS = append(cgoCPPFLAGS, pcCFLAGS...)
+	cgoLDFLAGS = append(cgoLDFLAGS, pcLDFLAGS...)
+	// If we are compiling Objective-C code, then we need to link against libobjc
+	if len(mfiles) > 0 {
+		cgoLDFLAGS = append(cgoLDFLAGS, "-lobjc")
+	}
+
+	// Likewise for Fortran, except there are many Fortran compilers.
+	// Support gfortran out of the box and let others pass the correct link options
+	// via CGO_LDFLAGS
+	if len(ffiles) > 0 {
+		if strings.Contains(strings.Join(cc.FC, " "), "gfortran") {
+			cgoLDFLAGS = append(cgoLDFLAGS, "-lgfortran")
+		}
+	}
+
+	// Allows including _cgo_export.h, as well as the user's .h files,
+	// from .[ch] files in the package.
+	cgoCPPFLAGS = append(cgoCPPFLAGS, "-I", objdir)
+
+	// cgo
+	// TODO: CGO_FLAGS?
+	gofiles := []string{objdir + "_cgo_gotypes.go"}
+	cfiles := []string{objdir + "_cgo_export.c"}
+	for _, fn := range cgofiles {
+		f := strings.TrimSuffix(filepath.Base(fn), ".go")
+		gofiles = append(gofiles, objdir+f+".cgo1.go")
+		cfiles = append(cfiles, objdir+f+".cgo2.c")
+	}
+
+	// TODO: make cgo not depend on $GOARCH?
+
+	cgoflags := []string{}
+	if p.Goroot && p.ImportPath == "runtime/cgo" {
+		cgoflags = append(cgoflags, "-import_runtime_cgo=false")
+	}
+	if p.Goroot && (p.ImportPath == "runtime/race" || p.ImportPath == "runtime/msan" || p.ImportPath == "runtime/cgo" || p.ImportPath == "runtime/asan") {
+		cgoflags = append(cgoflags, "-import_syscall=false")
+	}
+
+	// Rewrite the paths cgo records in //line and #line directives
+	// the same way the compiler does.
+	if rewrites := a.pathRewrites(ctx); len(rewrites) > 0 {
+		v, err := ctx.goVersion()
+		if err != nil {
+			return nil, nil, err
+		}
+		if v.has(featureCgoTrimpath) {
+			var trimpath []string
+			for _, r := range rewrites {
+				trimpath = append(trimpath, r.From+"=>"+r.To)
+			}
+			cgoflags = append(cgoflags, "-trimpath", strings.Join(trimpath, ";"))
+		}
+	}
+
+	tools, gccgo := t.(gccgoToolchain)
+	if gccgo {
+		if cc.supportsFlag([]string{tools.compiler()}, "-fsplit-stack") {
+			cgoCFLAGS = append(cgoCFLAGS, "-fsplit-stack")
+		}
+		cgoflags = append(cgoflags, "-gccgo")
+		if pkgpath := gccgoPkgpath(a); pkgpath != "" {
+			cgoflags = append(cgoflags, "-gccgopkgpath="+pkgpath)
+		}
+	}
+
+	// Update $CGO_LDFLAGS with p.CgoLDFLAGS.
+	// These flags are recorded in the generated _cgo_gotypes.go file
+	// using //go:cgo_ldflag directives, the compiler records them in the
+	// object file for the package, and then the Go linker passes them
+	// along to the host linker. At this point in the code, cgoLDFLAGS
+	// consists of the original $CGO_LDFLAGS (unchecked) and all the
+	// flags put together from source code (checked).
+	cgoenv := stringList(ctx.goarchEnv(), cc.env())
+	if len(cgoLDFLAGS) > 0 {
+		flags := make([]string, len(cgoLDFLAGS))
+		for i, f := range cgoLDFLAGS {
+			flags[i] = strconv.Quote(f)
+		}
+		cgoenv = append(cgoenv, "CGO_LDFLAGS="+strings.Join(flags, " "))
+	}
+
+	files := StepFiles{
+		Inputs:  append(mkAbsFiles(p.Dir, p.HFiles), cgofiles...),
+		Outputs: append(append([]string{objdir + "_cgo_export.h", objdir + "_cgo_main.c"}, gofiles...), cfiles...),
+	}
+	if gccgo {
+		files.Outputs = append(files.Outputs, objdir+"_cgo_defun.c", objdir+"_cgo_flags")
+	}
+
+	if err := exec.Run(a, files, cgoenv, ctx.GoTool, "tool", "cgo", "-objdir", objdir, "-importpath", p.ImportPath, cgoflags, "--", cgoCPPFLAGS, cgoCFLAGS, cgofiles); err != nil {
+		return nil, nil, err
+	}
+	outGo = append(outGo, gofiles...)
+
+	// Use sequential object file names to keep them distinct
+	// and short enough to fit in the .a header file name slots.
+	// We no longer collect them all into _all.o, and we'd like
+	// tools to see both the .o suffix and unique names, so
+	// we need to make them short enough not to be truncated
+	// in the final archive.
+	oseq := 0
+	nextOfile := func() string {
+		oseq++
+		return objdir + fmt.Sprintf("_x%03d.o", oseq)
+	}
+
+	// gcc
+	cflags := stringList(cgoCPPFLAGS, cgoCFLAGS)
+	for _, cfile := range cfiles {
+		ofile := nextOfile()
+		if err := cc.compile(exec, a, ofile, cflags, cfile); err != nil {
+			return nil, nil, err
+		}
+		outObj = append(outObj, ofile)
+	}
+
+	for _, file := range gccfiles {
+		ofile := nextOfile()
+		if err := cc.compile(exec, a, ofile, cflags, file); err != nil {
+			return nil, nil, err
+		}
+		outObj = append(outObj, ofile)
+	}
+
+	cxxflags := stringList(cgoCPPFLAGS, cgoCXXFLAGS)
+	for _, file := range gxxfiles {
+		ofile := nextOfile()
+		if err := cc.compile(exec, a, ofile, cxxflags, file); err != nil {
+			return nil, nil, err
+		}
+		outObj = append(outObj, ofile)
+	}
+
+	for _, file := range mfiles {
+		ofile := nextOfile()
+		if err := cc.compile(exec, a, ofile, cflags, file); err != nil {
+			return nil, nil, err
+		}
+		outObj = append(outObj, ofile)
+	}
+
+	fflags := stringList(cgoCPPFLAGS, cgoFFLAGS)
+	for _, file := range ffiles {
+		ofile := nextOfile()
+		if err := cc.compile(exec, a, ofile, fflags, file); err != nil {
+			return nil, nil, err
+		}
+		outObj = append(outObj, ofile)
+	}
+
+	if gccgo {
+		defunC := objdir + "_cgo_defun.c"
+		defunObj := objdir + "_cgo_defun.o"
+		if err := t.Cc(ctx, exec, a, defunObj, defunC); err != nil {
+			return nil, nil, err
+		}
+		outObj = append(outObj, defunObj)
+
+		// The gccgo linker gets the cgo LDFLAGS from the _cgo_flags file
+		// packed into the archive, not from //go:cgo_ldflag comments.
+		outObj = append(outObj, objdir+"_cgo_flags")
+
+		return outGo, outObj, nil
+	}
+
+	importGo := objdir + "_cgo_import.go"
+	dynOutGo, dynOutObj, err := dynimport(ctx, exec, cc, a, objdir, importGo, cflags, cgoLDFLAGS, outObj)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dynOutGo != "" {
+		outGo = append(outGo, dynOutGo)
+	}
+	if dynOutObj != "" {
+		outObj = append(outObj, dynOutObj)
+	}
+
+	// Double check the //go:cgo_ldflag comments in the generated files.
+	// The compiler only permits such comments in files whose base name
+	// starts with "_cgo_". Make sure that the comments in those files
+	// are safe. This is a backstop against people somehow smuggling
+	// such a comment into a file generated by cgo.
+	// The files can only be read now if the executor actually ran cgo.
+	// Otherwise the build checks them by running gb once cgo ran.
+	if stamp := cgoLdflagsStamp(exec, a); stamp != "" {
+		if err := checkCgoLdflagsStep(exec, a, stamp, outGo, cgoLDFLAGS); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		r, _ := executorFileReader(exec)
+		if err := checkCgoLdflags(r, outGo, cgoLDFLAGS); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return outGo, outObj, nil
+}
+
+// checkCgoLdflagsCmd is the gb command checking the //go:cgo_ldflag comments
+// in the files generated by cgo when the build runs:
+//
+//	gb check-cgo-ldflags stamp n cgoLDFLAGS[0] ... cgoLDFLAGS[n-1] files...
+//
+// The stamp file is written once the check passes.
+const checkCgoLdflagsCmd = "check-cgo-ldflags"
+
+// cgoLdflagsStamp returns the stamp file written by the step checking the
+// //go:cgo_ldflag comments in the files generated by cgo for a,
+// or "" if exec runs the steps and the comments are checked as cgo runs.
+// The compiler reads the checked files, so it depends on the stamp.
+func cgoLdflagsStamp(exec Executor, a Action) string {
+	if len(a.Package.CgoFiles) == 0 {
+		return ""
+	}
+	if _, ok := executorFileReader(exec); ok {
+		return ""
+	}
+
+	return a.Objdir + "_cgo_ldflags.ok"
+}
+
+// checkCgoLdflagsStep runs the check of checkCgoLdflags as a build step
+// writing the file stamp, for executors recording the steps rather than running them.
+//
+// The step runs the gb binary building the package, by its absolute path:
+// recorded builds only run on the machine they were recorded on,
+// and as long as the binary stays in place.
+func checkCgoLdflagsStep(exec Executor, a Action, stamp string, outGo []string, cgoLDFLAGS []string) error {
+	gb, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var checked []string
+	for _, f := range outGo {
+		if strings.HasPrefix(filepath.Base(f), "_cgo_") {
+			checked = append(checked, f)
+		}
+	}
+
+	files := StepFiles{
+		Inputs:  checked,
+		Outputs: []string{stamp},
+	}
+
+	return exec.Run(a, files, nil, gb, checkCgoLdflagsCmd, stamp, strconv.Itoa(len(cgoLDFLAGS)), cgoLDFLAGS, checked)
+}
+
+// runCheckCgoLdflags runs the check-cgo-ldflags command with args.
+func runCheckCgoLdflags(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: gb %s stamp n cgoLDFLAGS... files...", checkCgoLdflagsCmd)
+	}
+
+	stamp := args[0]
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return fmt.Errorf("usage: gb %s stamp n cgoLDFLAGS... files...", checkCgoLdflagsCmd)
+	}
+
+	if err := checkCgoLdflags(newLocalExecutor(""), args[2+n:], args[2:2+n]); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(stamp, nil, 0666)
+}
+
+// checkCgoLdflags checks the //go:cgo_ldflag comments in the files generated by cgo,
+// except for cgoLDFLAGS, the flags cgo was run with.
+func checkCgoLdflags(r fileReader, outGo []string, cgoLDFLAGS []string) error {
+	var flags []string
+	for _, f := range outGo {
+		if !strings.HasPrefix(filepath.Base(f), "_cgo_") {
+			continue
+		}
+
+		src, err := r.ReadFile(f)
+		if err != nil {
+			return err
+		}
+
+		const cgoLdflag = "//go:cgo_ldflag"
+		idx := bytes.Index(src, []byte(cgoLdflag))
+		for idx >= 0 {
+			// We are looking at //go:cgo_ldflag.
+			// Find start of line.
+			start := bytes.LastIndex(src[:idx], []byte("\n"))
+			if start == -1 {
+				start = 0
+			}
+
+			// Find end of line.
+			end := bytes.Index(src[idx:], []byte("\n"))
+			if end == -1 {
+				end = len(src)
+			} else {
+				end += idx
+			}
+
+			// Check for first line comment in line.
+			// We don't worry about /* */ comments,
+			// which normally won't appear in files
+			// generated by cgo.
+			commentStart := bytes.Index(src[start:], []byte("//"))
+			commentStart += start
+			// If that line comment is //go:cgo_ldflag,
+			// it's a match.
+			if bytes.HasPrefix(src[commentStart:], []byte(cgoLdflag)) {
+				// Pull out the flag, and unquote it.
+				// This is what the compiler does.
+				flag := string(src[idx+len(cgoLdflag) : end])
+				flag = strings.TrimSpace(flag)
+				flag = strings.Trim(flag, `"`)
+				flags = append(flags, flag)
+			}
+			src = src[end:]
+			idx = bytes.Index(src, []byte(cgoLdflag))
+		}
+	}
+
+	// We expect to find the contents of cgoLDFLAGS in flags.
+	if len(cgoLDFLAGS) > 0 {
+	outer:
+		for i := range flags {
+			if i+len(cgoLDFLAGS) > len(flags) {
+				break
+			}
+			for j, f := range cgoLDFLAGS {
+				if f != flags[i+j] {
+					continue outer
+				}
+			}
+			flags = append(flags[:i], flags[i+len(cgoLDFLAGS):]...)
+			break
+		}
+	}
+
+	return checkLinkerFlags("LDFLAGS", "go:cgo_ldflag", flags)
+}
+
+// fileReader is implemented by executors running the build steps,
+// which can read back the files written by the steps.
+type fileReader interface {
+	ReadFile(path string) ([]byte, error)
+}
+
+// executorFileReader returns exec, or the executor it wraps, as a fileReader.
+func executorFileReader(exec Executor) (fileReader, bool) {
+	for exec != nil {
+		if r, ok := exec.(fileReader); ok {
+			return r, true
+		}
+
+		u, ok := exec.(interface{ Unwrap() Executor })
+		if !ok {
+			break
+		}
+		exec = u.Unwrap()
+	}
+
+	return nil, false
+}
+
+// dynimport creates a Go source file named importGo containing
+// //go:cgo_import_dynamic directives for each symbol or library
+// dynamically imported by the object files outObj.
+// dynOutGo, if not empty, is a new Go file to build as part of the package.
+// dynOutObj, if not empty, is a new file to add to the generated archive.
+func dynimport(ctx Context, exec Executor, cc cToolchain, a Action, objdir, importGo string, cflags, cgoLDFLAGS, outObj []string) (dynOutGo, dynOutObj string, err error) {
+	p := a.Package
+
+	cfile := objdir + "_cgo_main.c"
+	ofile := objdir + "_cgo_main.o"
+	if err := cc.compile(exec, a, ofile, cflags, cfile); err != nil {
+		return "", "", err
+	}
+
+	linkobj := stringList(ofile, outObj, mkAbsFiles(p.Dir, p.SysoFiles))
+	dynobj := objdir + "_cgo_.o"
+
+	ldflags := cgoLDFLAGS
+	if (ctx.GOARCH == "arm" && ctx.GOOS == "linux") || ctx.GOOS == "android" {
+		// we need to use -pie for Linux/ARM to get accurate imported sym
+		ldflags = append(ldflags, "-pie")
+	}
+	if err := cc.link(exec, a, dynobj, ldflags, linkobj); err != nil {
+		// We only need this information for internal linking.
+		// If this link fails, mark the object as requiring
+		// external linking. This link can fail for things like
+		// syso files that have unexpected dependencies.
+		// cmd/link explicitly looks for the name "dynimportfail".
+		// See issue #52863.
+		fail := objdir + "dynimportfail"
+		if err := exec.WriteFile(fail, nil); err != nil {
+			return "", "", err
+		}
+		return "", fail, nil
+	}
+
+	// cgo -dynimport
+	var cgoflags []string
+	if p.Goroot && p.ImportPath == "runtime/cgo" {
+		cgoflags = []string{"-dynlinker"} // record path to dynamic linker
+	}
+
+	files := StepFiles{
+		Inputs:  []string{dynobj},
+		Outputs: []string{importGo},
+	}
+
+	err = exec.Run(a, files, stringList(ctx.goarchEnv(), cc.env()), ctx.GoTool, "tool", "cgo", "-dynpackage", p.Name, "-dynimport", dynobj, "-dynout", importGo, cgoflags)
+	if err != nil {
+		return "", "", err
+	}
+	return importGo, "", nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// mapFileReader reads files from a map.
+type mapFileReader map[string]string
+
+func (r mapFileReader) ReadFile(path string) ([]byte, error) {
+	data, ok := r[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	return []byte(data), nil
+}
+
+func TestCheckCgoLdflags(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		cgoLDFLAGS []string
+		ok         bool
+	}{
+		{
+			name:  "none",
+			files: map[string]string{"_cgo_gotypes.go": "package p\n"},
+			ok:    true,
+		},
+		{
+			name: "cgo LDFLAGS",
+			files: map[string]string{
+				"_cgo_gotypes.go": "package p\n\n//go:cgo_ldflag \"-L/usr/lib\"\n//go:cgo_ldflag \"-lfoo\"\n",
+			},
+			cgoLDFLAGS: []string{"-L/usr/lib", "-lfoo"},
+			ok:         true,
+		},
+		{
+			name: "cgo LDFLAGS only skip their own flags",
+			files: map[string]string{
+				"_cgo_gotypes.go": "package p\n\n//go:cgo_ldflag \"-fplugin=evil.so\"\n//go:cgo_ldflag \"-lfoo\"\n",
+			},
+			cgoLDFLAGS: []string{"-lfoo"},
+		},
+		{
+			name: "smuggled flag",
+			files: map[string]string{
+				"_cgo_gotypes.go": "package p\n",
+				"_cgo_import.go":  "package p\n\nvar x = 1 //go:cgo_ldflag \"-fplugin=evil.so\"\n",
+			},
+		},
+		{
+			name: "line comment after another one",
+			files: map[string]string{
+				"_cgo_gotypes.go": "package p\n\n// see //go:cgo_ldflag \"-fplugin=evil.so\"\n",
+			},
+			ok: true,
+		},
+		{
+			name: "files not generated by cgo",
+			files: map[string]string{
+				"_cgo_gotypes.go": "package p\n",
+				"x.cgo1.go":       "package p\n\n//go:cgo_ldflag \"-fplugin=evil.so\"\n",
+			},
+			ok: true,
+		},
+	}
+
+	for _, test := range tests {
+		var outGo []string
+		for name := range test.files {
+			outGo = append(outGo, name)
+		}
+
+		err := checkCgoLdflags(mapFileReader(test.files), outGo, test.cgoLDFLAGS)
+		if test.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: missing error", test.name)
+		}
+	}
+}
+
+func TestRunCheckCgoLdflags(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"_cgo_gotypes.go": "package p\n\n//go:cgo_ldflag \"-lfoo\"\n",
+		"_cgo_import.go":  "package p\n\n//go:cgo_ldflag \"-fplugin=evil.so\"\n",
+	})
+	gotypes := filepath.Join(dir, "_cgo_gotypes.go")
+	imports := filepath.Join(dir, "_cgo_import.go")
+
+	tests := []struct {
+		name       string
+		cgoLDFLAGS []string
+		files      []string
+		ok         bool
+	}{
+		{name: "valid", cgoLDFLAGS: []string{"-lfoo"}, files: []string{gotypes}, ok: true},
+		{name: "invalid", cgoLDFLAGS: []string{"-lfoo"}, files: []string{gotypes, imports}},
+	}
+
+	for _, test := range tests {
+		stamp := filepath.Join(dir, test.name+".ok")
+		args := append([]string{stamp, strconv.Itoa(len(test.cgoLDFLAGS))}, test.cgoLDFLAGS...)
+
+		err := runCheckCgoLdflags(append(args, test.files...))
+		if test.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: missing error", test.name)
+		}
+
+		// The stamp is only written if the check passes.
+		_, err = ioutil.ReadFile(stamp)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.ok && !os.IsNotExist(err) {
+			t.Errorf("%s: stamp written after the check failed: %v", test.name, err)
+		}
+	}
+
+	for _, args := range [][]string{nil, {"stamp"}, {"stamp", "x"}, {"stamp", "2", "-lfoo"}} {
+		if err := runCheckCgoLdflags(args); err == nil {
+			t.Errorf("runCheckCgoLdflags(%q): missing usage error", args)
+		}
+	}
+}
+
+func TestCheckCgoLdflagsStep(t *testing.T) {
+	ctx := testContext(t)
+	if !ctx.CgoEnabled {
+		t.Skip("cgo is disabled")
+	}
+
+	_, actions := loadTestActions(t, ctx, map[string]string{
+		"go.mod":  "module example.com/p\n\ngo 1.20\n",
+		"main.go": "package main\n\n// #cgo LDFLAGS: -lm\nimport \"C\"\n\nfunc main() {}\n",
+	}, ".")
+	a := actions[len(actions)-1]
+
+	steps, err := Plan(ctx, gcToolchain{}, *a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The check writes a stamp, which the compiler waits for.
+	stamp := a.Objdir + "_cgo_ldflags.ok"
+	var checked bool
+	for _, s := range steps {
+		switch {
+		case len(s.Args) > 1 && s.Args[1] == checkCgoLdflagsCmd:
+			checked = true
+			if len(s.Outputs) != 1 || s.Outputs[0] != stamp {
+				t.Errorf("check outputs %q, want %s", s.Outputs, stamp)
+			}
+
+		case s.Tool == "compile":
+			if !checked {
+				t.Fatalf("compile runs before the check")
+			}
+			if !stringsContain(s.Inputs, stamp) {
+				t.Errorf("compile inputs %q do not include %s", s.Inputs, stamp)
+			}
+		}
+	}
+	if !checked {
+		t.Errorf("no step checks the //go:cgo_ldflag comments")
+	}
+}
+
+// stringsContain reports whether list contains s.
+func stringsContain(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
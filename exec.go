@@ -9,18 +9,91 @@ import (
 )
 
 // Executor interacts with the environment and executes build steps.
+//
+// Commands whose output decides the commands of the build
+// (eg. pkg-config, go env or probes of the C compiler's flags)
+// run when the build is set up rather than through an Executor,
+// which may only record the steps.
 type Executor interface {
-	Run(a Action, env []string, cmdargs ...interface{}) error
+	// Run runs the command described by cmdargs for an action.
+	// Each argument in cmdargs must have type string or []string.
+	// Files declares the files the command reads and writes.
+	Run(a Action, files StepFiles, env []string, cmdargs ...interface{}) error
 
 	WriteFile(path string, content []byte) error
 }
 
+// StepFiles declares the files a build step reads and writes.
+// Executors can rely on it to sandbox, cache or order steps.
+type StepFiles struct {
+	Inputs  []string
+	Outputs []string
+}
+
 type Context struct {
 	GOROOT string
 	GOOS   string
 	GOARCH string
 
+	// GOAMD64, GOARM, GO386, GOMIPS, GOMIPS64 and GOPPC64 select the
+	// microarchitecture level of the GOARCH they apply to, eg. "v3" or "7".
+	// If empty, the environment variable of the same name or the default
+	// of the go command is used.
+	GOAMD64  string
+	GOARM    string
+	GO386    string
+	GOMIPS   string
+	GOMIPS64 string
+	GOPPC64  string
+
 	GoTool string
+
+	// GOMODCACHE is the module cache directory.
+	// If empty, $GOMODCACHE or the pkg/mod directory of the first GOPATH entry is used.
+	GOMODCACHE string
+
+	// GOWORK is the go.work file of the workspace, or "off" to build
+	// without one. If empty, $GOWORK or the closest go.work file in or
+	// above the directory the build runs in is used.
+	GOWORK string
+
+	// Mod is "vendor" to import the packages of required modules from the
+	// vendor directory of the main module, "mod" to import them from the
+	// module cache, or empty to use the vendor directory if there is one,
+	// as the go command does.
+	Mod string
+
+	// CgoEnabled reports whether packages may use cgo.
+	CgoEnabled bool
+
+	// BuildTags are the additional build tags satisfied when selecting source files.
+	BuildTags []string
+
+	// Compiler is the name of the toolchain building packages (see NewToolchain).
+	// If empty, the gc toolchain is used.
+	Compiler string
+
+	// CC and CXX are the C and C++ compilers.
+	// If empty, $CC or gcc and $CXX or g++ are used.
+	CC  string
+	CXX string
+
+	// CPPFLAGS, CFLAGS and CXXFLAGS are passed to the C and C++ compilers.
+	// If nil, $CGO_CPPFLAGS, $CGO_CFLAGS and $CGO_CXXFLAGS are used.
+	CPPFLAGS []string
+	CFLAGS   []string
+	CXXFLAGS []string
+
+	// Trimpath selects how the paths of source files are recorded.
+	Trimpath TrimpathMode
+
+	// TrimpathMap maps directories to the paths recorded in their place
+	// when Trimpath is TrimpathCustom.
+	TrimpathMap map[string]string
+
+	// PkgConfig is the pkg-config binary resolving #cgo pkg-config directives.
+	// If empty, $PKG_CONFIG or pkg-config is used.
+	PkgConfig string
 }
 
 // Build is the action for building a single package.
@@ -33,8 +106,17 @@ func Build(ctx Context, exec Executor, t Toolchain, a Action) (err error) {
 	cfiles := a.Package.CFiles
 	sfiles := a.Package.SFiles
 	cxxfiles := a.Package.CXXFiles
+	mfiles := a.Package.MFiles
+	ffiles := a.Package.FFiles
 	var objects, cgoObjects, pcCFLAGS, pcLDFLAGS []string
 
+	if len(a.Package.CgoPkgConfig) > 0 {
+		pcCFLAGS, pcLDFLAGS, err = pkgConfigFlags(ctx, a)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Run cgo.
 	if len(a.Package.CgoFiles) > 0 {
 		// In a package using cgo, cgo compiles the C, C++ and assembly files with gcc.
@@ -44,29 +126,41 @@ func Build(ctx Context, exec Executor, t Toolchain, a Action) (err error) {
 		var gccfiles []string
 		gccfiles = append(gccfiles, cfiles...)
 		cfiles = nil
-
-		for _, sfile := range sfiles {
-			data, err := ioutil.ReadFile(filepath.Join(a.Package.Dir, sfile))
-			if err == nil {
-				if bytes.HasPrefix(data, []byte("TEXT")) || bytes.Contains(data, []byte("\nTEXT")) ||
-					bytes.HasPrefix(data, []byte("DATA")) || bytes.Contains(data, []byte("\nDATA")) ||
-					bytes.HasPrefix(data, []byte("GLOBL")) || bytes.Contains(data, []byte("\nGLOBL")) {
-					return fmt.Errorf("package using cgo has Go assembly file %s", sfile)
+		if a.Package.Goroot && a.Package.ImportPath == "runtime/cgo" {
+			filter := func(files, nongcc, gcc []string) ([]string, []string) {
+				for _, f := range files {
+					if strings.HasPrefix(f, "gcc_") {
+						gcc = append(gcc, f)
+					} else {
+						nongcc = append(nongcc, f)
+					}
 				}
+				return nongcc, gcc
 			}
+			sfiles, gccfiles = filter(sfiles, sfiles[:0:0], gccfiles)
+		} else {
+			for _, sfile := range sfiles {
+				data, err := ioutil.ReadFile(filepath.Join(a.Package.Dir, sfile))
+				if err == nil {
+					if bytes.HasPrefix(data, []byte("TEXT")) || bytes.Contains(data, []byte("\nTEXT")) ||
+						bytes.HasPrefix(data, []byte("DATA")) || bytes.Contains(data, []byte("\nDATA")) ||
+						bytes.HasPrefix(data, []byte("GLOBL")) || bytes.Contains(data, []byte("\nGLOBL")) {
+						return fmt.Errorf("package using cgo has Go assembly file %s", sfile)
+					}
+				}
+			}
+			gccfiles = append(gccfiles, sfiles...)
+			sfiles = nil
 		}
-		gccfiles = append(gccfiles, sfiles...)
-		sfiles = nil
 
-		outGo, outObj, err := cgo(a, base.Tool("cgo"), objdir, pcCFLAGS, pcLDFLAGS, mkAbsFiles(a.Package.Dir, cgofiles), gccfiles, cxxfiles, a.Package.MFiles, a.Package.FFiles)
+		outGo, outObj, err := cgo(ctx, exec, t, a, objdir, pcCFLAGS, pcLDFLAGS, mkAbsFiles(a.Package.Dir, cgofiles), gccfiles, cxxfiles, mfiles, ffiles)
 		if err != nil {
 			return err
 		}
-		if "" == "gccgo" {
-			cgoObjects = append(cgoObjects, a.Objdir+"_cgo_flags")
-		}
 		cgoObjects = append(cgoObjects, outObj...)
 		gofiles = append(gofiles, outGo...)
+
+		cxxfiles, mfiles, ffiles = nil, nil, nil
 	}
 
 	var srcfiles []string // .go and non-.go
@@ -81,9 +175,16 @@ func Build(ctx Context, exec Executor, t Toolchain, a Action) (err error) {
 		return err
 	}
 
+	// Write the import configuration of the compiler.
+	if a.Importcfg != "" {
+		if err := exec.WriteFile(a.Importcfg, importcfg(a)); err != nil {
+			return err
+		}
+	}
+
 	// Compile Go.
-	objpkg := objdir + "_pkg_.a"
-	ofile, err := t.Gc(ctx, exec, a, a.Importcfg, objpkg, symabis, len(sfiles) > 0, gofiles)
+	objpkg := a.archive()
+	ofile, err := t.Gc(ctx, exec, a, objpkg, a.Importcfg, symabis, len(sfiles) > 0, gofiles)
 	if err != nil {
 		return err
 	}
@@ -91,9 +192,12 @@ func Build(ctx Context, exec Executor, t Toolchain, a Action) (err error) {
 		objects = append(objects, ofile)
 	}
 
-	for _, file := range cfiles {
-		out := file[:len(file)-len(".c")] + ".o"
-		if err := t.Cc(ctx, exec, a, objdir+out, file); err != nil {
+	// Compile C, C++, Objective-C and Fortran files.
+	// Like the objects of cgo, the objects are numbered sequentially:
+	// foo.c and foo.cc, or foo.c and the foo.o of foo.s, must not clash.
+	for i, file := range stringList(cfiles, cxxfiles, mfiles, ffiles) {
+		out := objdir + fmt.Sprintf("_x%03d.o", i+1)
+		if err := t.Cc(ctx, exec, a, out, file); err != nil {
 			return err
 		}
 		objects = append(objects, out)
@@ -132,268 +236,3 @@ func Build(ctx Context, exec Executor, t Toolchain, a Action) (err error) {
 
 	return nil
 }
-
-func cgo(a *Action, cgoExe, objdir string, pcCFLAGS, pcLDFLAGS, cgofiles, gccfiles, gxxfiles, mfiles, ffiles []string) (outGo, outObj []string, err error) {
-	p := a.Package
-	cgoCPPFLAGS, cgoCFLAGS, cgoCXXFLAGS, cgoFFLAGS, cgoLDFLAGS, err := b.CFlags(p)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	cgoCPPFLAGS = append(cgoCPPFLAGS, pcCFLAGS...)
-	cgoLDFLAGS = append(cgoLDFLAGS, pcLDFLAGS...)
-	// If we are compiling Objective-C code, then we need to link against libobjc
-	if len(mfiles) > 0 {
-		cgoLDFLAGS = append(cgoLDFLAGS, "-lobjc")
-	}
-
-	// Likewise for Fortran, except there are many Fortran compilers.
-	// Support gfortran out of the box and let others pass the correct link options
-	// via CGO_LDFLAGS
-	if len(ffiles) > 0 {
-		fc := cfg.Getenv("FC")
-		if fc == "" {
-			fc = "gfortran"
-		}
-		if strings.Contains(fc, "gfortran") {
-			cgoLDFLAGS = append(cgoLDFLAGS, "-lgfortran")
-		}
-	}
-
-	if cfg.BuildMSan {
-		cgoCFLAGS = append([]string{"-fsanitize=memory"}, cgoCFLAGS...)
-		cgoLDFLAGS = append([]string{"-fsanitize=memory"}, cgoLDFLAGS...)
-	}
-
-	// Allows including _cgo_export.h, as well as the user's .h files,
-	// from .[ch] files in the package.
-	cgoCPPFLAGS = append(cgoCPPFLAGS, "-I", objdir)
-
-	// cgo
-	// TODO: CGO_FLAGS?
-	gofiles := []string{objdir + "_cgo_gotypes.go"}
-	cfiles := []string{"_cgo_export.c"}
-	for _, fn := range cgofiles {
-		f := strings.TrimSuffix(filepath.Base(fn), ".go")
-		gofiles = append(gofiles, objdir+f+".cgo1.go")
-		cfiles = append(cfiles, f+".cgo2.c")
-	}
-
-	// TODO: make cgo not depend on $GOARCH?
-
-	cgoflags := []string{}
-	if p.Standard && p.ImportPath == "runtime/cgo" {
-		cgoflags = append(cgoflags, "-import_runtime_cgo=false")
-	}
-	if p.Standard && (p.ImportPath == "runtime/race" || p.ImportPath == "runtime/msan" || p.ImportPath == "runtime/cgo") {
-		cgoflags = append(cgoflags, "-import_syscall=false")
-	}
-
-	// Update $CGO_LDFLAGS with p.CgoLDFLAGS.
-	// These flags are recorded in the generated _cgo_gotypes.go file
-	// using //go:cgo_ldflag directives, the compiler records them in the
-	// object file for the package, and then the Go linker passes them
-	// along to the host linker. At this point in the code, cgoLDFLAGS
-	// consists of the original $CGO_LDFLAGS (unchecked) and all the
-	// flags put together from source code (checked).
-	cgoenv := b.cCompilerEnv()
-	if len(cgoLDFLAGS) > 0 {
-		flags := make([]string, len(cgoLDFLAGS))
-		for i, f := range cgoLDFLAGS {
-			flags[i] = strconv.Quote(f)
-		}
-		cgoenv = []string{"CGO_LDFLAGS=" + strings.Join(flags, " ")}
-	}
-
-	if cfg.BuildToolchainName == "gccgo" {
-		if b.gccSupportsFlag([]string{BuildToolchain.compiler()}, "-fsplit-stack") {
-			cgoCFLAGS = append(cgoCFLAGS, "-fsplit-stack")
-		}
-		cgoflags = append(cgoflags, "-gccgo")
-		if pkgpath := gccgoPkgpath(p); pkgpath != "" {
-			cgoflags = append(cgoflags, "-gccgopkgpath="+pkgpath)
-		}
-	}
-
-	switch cfg.BuildBuildmode {
-	case "c-archive", "c-shared":
-		// Tell cgo that if there are any exported functions
-		// it should generate a header file that C code can
-		// #include.
-		cgoflags = append(cgoflags, "-exportheader="+objdir+"_cgo_install.h")
-	}
-
-	execdir := p.Dir
-
-	// Rewrite overlaid paths in cgo files.
-	// cgo adds //line and #line pragmas in generated files with these paths.
-	var trimpath []string
-	for i := range cgofiles {
-		path := mkAbs(p.Dir, cgofiles[i])
-		if opath, ok := fsys.OverlayPath(path); ok {
-			cgofiles[i] = opath
-			trimpath = append(trimpath, opath+"=>"+path)
-		}
-	}
-	if len(trimpath) > 0 {
-		cgoflags = append(cgoflags, "-trimpath", strings.Join(trimpath, ";"))
-	}
-
-	if err := b.run(a, execdir, p.ImportPath, cgoenv, cfg.BuildToolexec, cgoExe, "-objdir", objdir, "-importpath", p.ImportPath, cgoflags, "--", cgoCPPFLAGS, cgoCFLAGS, cgofiles); err != nil {
-		return nil, nil, err
-	}
-	outGo = append(outGo, gofiles...)
-
-	// Use sequential object file names to keep them distinct
-	// and short enough to fit in the .a header file name slots.
-	// We no longer collect them all into _all.o, and we'd like
-	// tools to see both the .o suffix and unique names, so
-	// we need to make them short enough not to be truncated
-	// in the final archive.
-	oseq := 0
-	nextOfile := func() string {
-		oseq++
-		return objdir + fmt.Sprintf("_x%03d.o", oseq)
-	}
-
-	// gcc
-	cflags := str.StringList(cgoCPPFLAGS, cgoCFLAGS)
-	for _, cfile := range cfiles {
-		ofile := nextOfile()
-		if err := b.gcc(a, p, a.Objdir, ofile, cflags, objdir+cfile); err != nil {
-			return nil, nil, err
-		}
-		outObj = append(outObj, ofile)
-	}
-
-	for _, file := range gccfiles {
-		ofile := nextOfile()
-		if err := b.gcc(a, p, a.Objdir, ofile, cflags, file); err != nil {
-			return nil, nil, err
-		}
-		outObj = append(outObj, ofile)
-	}
-
-	cxxflags := str.StringList(cgoCPPFLAGS, cgoCXXFLAGS)
-	for _, file := range gxxfiles {
-		ofile := nextOfile()
-		if err := b.gxx(a, p, a.Objdir, ofile, cxxflags, file); err != nil {
-			return nil, nil, err
-		}
-		outObj = append(outObj, ofile)
-	}
-
-	for _, file := range mfiles {
-		ofile := nextOfile()
-		if err := b.gcc(a, p, a.Objdir, ofile, cflags, file); err != nil {
-			return nil, nil, err
-		}
-		outObj = append(outObj, ofile)
-	}
-
-	fflags := str.StringList(cgoCPPFLAGS, cgoFFLAGS)
-	for _, file := range ffiles {
-		ofile := nextOfile()
-		if err := b.gfortran(a, p, a.Objdir, ofile, fflags, file); err != nil {
-			return nil, nil, err
-		}
-		outObj = append(outObj, ofile)
-	}
-
-	switch cfg.BuildToolchainName {
-	case "gc":
-		importGo := objdir + "_cgo_import.go"
-		if err := b.dynimport(a, p, objdir, importGo, cgoExe, cflags, cgoLDFLAGS, outObj); err != nil {
-			return nil, nil, err
-		}
-		outGo = append(outGo, importGo)
-
-	case "gccgo":
-		defunC := objdir + "_cgo_defun.c"
-		defunObj := objdir + "_cgo_defun.o"
-		if err := BuildToolchain.cc(b, a, defunObj, defunC); err != nil {
-			return nil, nil, err
-		}
-		outObj = append(outObj, defunObj)
-
-	default:
-		noCompiler()
-	}
-
-	// Double check the //go:cgo_ldflag comments in the generated files.
-	// The compiler only permits such comments in files whose base name
-	// starts with "_cgo_". Make sure that the comments in those files
-	// are safe. This is a backstop against people somehow smuggling
-	// such a comment into a file generated by cgo.
-	if cfg.BuildToolchainName == "gc" && !cfg.BuildN {
-		var flags []string
-		for _, f := range outGo {
-			if !strings.HasPrefix(filepath.Base(f), "_cgo_") {
-				continue
-			}
-
-			src, err := os.ReadFile(f)
-			if err != nil {
-				return nil, nil, err
-			}
-
-			const cgoLdflag = "//go:cgo_ldflag"
-			idx := bytes.Index(src, []byte(cgoLdflag))
-			for idx >= 0 {
-				// We are looking at //go:cgo_ldflag.
-				// Find start of line.
-				start := bytes.LastIndex(src[:idx], []byte("\n"))
-				if start == -1 {
-					start = 0
-				}
-
-				// Find end of line.
-				end := bytes.Index(src[idx:], []byte("\n"))
-				if end == -1 {
-					end = len(src)
-				} else {
-					end += idx
-				}
-
-				// Check for first line comment in line.
-				// We don't worry about /* */ comments,
-				// which normally won't appear in files
-				// generated by cgo.
-				commentStart := bytes.Index(src[start:], []byte("//"))
-				commentStart += start
-				// If that line comment is //go:cgo_ldflag,
-				// it's a match.
-				if bytes.HasPrefix(src[commentStart:], []byte(cgoLdflag)) {
-					// Pull out the flag, and unquote it.
-					// This is what the compiler does.
-					flag := string(src[idx+len(cgoLdflag) : end])
-					flag = strings.TrimSpace(flag)
-					flag = strings.Trim(flag, `"`)
-					flags = append(flags, flag)
-				}
-				src = src[end:]
-				idx = bytes.Index(src, []byte(cgoLdflag))
-			}
-		}
-
-		// We expect to find the contents of cgoLDFLAGS in flags.
-		if len(cgoLDFLAGS) > 0 {
-		outer:
-			for i := range flags {
-				for j, f := range cgoLDFLAGS {
-					if f != flags[i+j] {
-						continue outer
-					}
-				}
-				flags = append(flags[:i], flags[i+len(cgoLDFLAGS):]...)
-				break
-			}
-		}
-
-		if err := checkLinkerFlags("LDFLAGS", "go:cgo_ldflag", flags); err != nil {
-			return nil, nil, err
-		}
-	}
-
-	return outGo, outObj, nil
-}
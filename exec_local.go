@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// localExecutor runs build steps as processes on the local machine.
+type localExecutor struct {
+	// Dir is the directory commands are run in.
+	// If empty, commands run in the source directory of the package being built.
+	Dir string
+
+	// Env is the base environment of every command.
+	// The environment passed to Run is merged on top of it.
+	Env []string
+
+	ctx    context.Context
+	output *actionOutput
+}
+
+// actionOutput collects the output of commands per action.
+type actionOutput struct {
+	mu  sync.Mutex
+	out map[string]*bytes.Buffer
+}
+
+func newLocalExecutor(dir string) *localExecutor {
+	return &localExecutor{
+		Dir: dir,
+		Env: os.Environ(),
+		ctx: context.Background(),
+		output: &actionOutput{
+			out: make(map[string]*bytes.Buffer),
+		},
+	}
+}
+
+// WithContext returns an executor killing running commands when ctx is done.
+// The returned executor shares recorded output with e.
+func (e *localExecutor) WithContext(ctx context.Context) Executor {
+	e2 := *e
+	e2.ctx = ctx
+
+	return &e2
+}
+
+// Run runs the command described by cmdargs.
+// The combined stdout and stderr of the command is recorded for the action,
+// or returned in a *runError if the command fails.
+// Declared files are not enforced: the command sees the whole file system.
+func (e *localExecutor) Run(a Action, files StepFiles, env []string, cmdargs ...interface{}) error {
+	args := stringList(cmdargs...)
+	if len(args) == 0 {
+		return fmt.Errorf("%s: empty command", a.Package.ImportPath)
+	}
+
+	if a.Objdir != "" {
+		if err := os.MkdirAll(a.Objdir, 0777); err != nil {
+			return err
+		}
+	}
+
+	dir := e.Dir
+	if dir == "" {
+		dir = a.Package.Dir
+	}
+
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(e.ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = mergeEnvLists(env, e.Env)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+
+	if err := cmd.Run(); err != nil {
+		return &runError{
+			ImportPath: a.Package.ImportPath,
+			Dir:        dir,
+			Cmdline:    joinUnambiguously(args),
+			Output:     buf.Bytes(),
+			Err:        err,
+		}
+	}
+
+	e.output.mu.Lock()
+	out, ok := e.output.out[a.Package.ImportPath]
+	if !ok {
+		out = new(bytes.Buffer)
+		e.output.out[a.Package.ImportPath] = out
+	}
+	out.Write(buf.Bytes())
+	e.output.mu.Unlock()
+
+	return nil
+}
+
+// WriteFile writes content to path, creating parent directories as needed.
+func (e *localExecutor) WriteFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, content, 0666)
+}
+
+// ReadFile reads a file written by a step.
+func (e *localExecutor) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+// Output returns the output recorded so far for the commands run for an action.
+func (e *localExecutor) Output(a Action) []byte {
+	e.output.mu.Lock()
+	defer e.output.mu.Unlock()
+
+	if out, ok := e.output.out[a.Package.ImportPath]; ok {
+		return append([]byte(nil), out.Bytes()...)
+	}
+
+	return nil
+}
+
+// runError is returned by localExecutor when a command fails.
+type runError struct {
+	ImportPath string
+	Dir        string
+	Cmdline    string
+	Output     []byte
+	Err        error
+}
+
+func (e *runError) Error() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# %s\n", e.ImportPath)
+	fmt.Fprintf(&buf, "cd %s\n", e.Dir)
+	fmt.Fprintf(&buf, "%s: %v", e.Cmdline, e.Err)
+	if len(e.Output) > 0 {
+		buf.WriteByte('\n')
+		buf.Write(bytes.TrimRight(e.Output, "\n"))
+	}
+
+	return buf.String()
+}
+
+func (e *runError) Unwrap() error {
+	return e.Err
+}
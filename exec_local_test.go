@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalExecutorRun(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		env    []string
+		script string
+		output string
+		err    string
+	}{
+		{name: "output", script: "echo out; echo err >&2", output: "out\nerr\n"},
+		{name: "directory", script: "pwd", output: dir + "\n"},
+		{name: "environment", env: []string{"GB_TEST=value"}, script: "echo $GB_TEST", output: "value\n"},
+		{name: "failure", script: "echo failed; exit 3", output: "failed\n", err: "exit status 3"},
+	}
+
+	for _, test := range tests {
+		exec := newLocalExecutor("")
+		a := Action{
+			Package: Package{
+				Package: &build.Package{ImportPath: "example.com/" + test.name, Dir: dir},
+			},
+			Objdir: filepath.Join(t.TempDir(), "b001") + string(filepath.Separator),
+		}
+
+		err := exec.Run(a, StepFiles{}, test.env, "sh", "-c", test.script)
+		switch {
+		case test.err != "":
+			var rerr *runError
+			if !errors.As(err, &rerr) || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			} else if string(rerr.Output) != test.output {
+				t.Errorf("%s: error output %q, want %q", test.name, rerr.Output, test.output)
+			}
+
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		// The output of failed commands is only reported in their error.
+		output := test.output
+		if test.err != "" {
+			output = ""
+		}
+		if got := string(exec.Output(a)); got != output {
+			t.Errorf("%s: output %q, want %q", test.name, got, output)
+		}
+	}
+}
+
+func TestLocalExecutorWriteFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "b001", "importcfg")
+
+	exec := newLocalExecutor("")
+	if err := exec.WriteFile(file, []byte("packagefile fmt=fmt.a\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "packagefile fmt=fmt.a\n" {
+		t.Errorf("file content %q", data)
+	}
+}
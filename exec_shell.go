@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// shellExecutor records build steps and renders them as a POSIX shell script.
+//
+// Occurrences of WorkDir (and of the $WORK pseudo-directory) are written
+// as references to the $WORK shell variable, so the script can be replayed
+// against a fresh work directory.
+type shellExecutor struct {
+	// WorkDir is the real work directory of the build, if any.
+	WorkDir string
+
+	// Exec, if set, runs each step after it is recorded.
+	// This is how -x traces a real build.
+	Exec Executor
+
+	// Trace, if set, receives each step as soon as it is recorded,
+	// before it runs.
+	Trace io.Writer
+
+	// script is shared with the executors returned by WithContext.
+	script *shellScript
+}
+
+// shellScript is the script recorded by a shellExecutor.
+type shellScript struct {
+	// mu guards the script, so every step is recorded as a whole
+	// when actions are built in parallel.
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	dir  string
+	dirs map[string]bool
+}
+
+func newShellExecutor(workDir string, exec Executor) *shellExecutor {
+	return &shellExecutor{
+		WorkDir: workDir,
+		Exec:    exec,
+		script: &shellScript{
+			dirs: make(map[string]bool),
+		},
+	}
+}
+
+// WithContext returns an executor recording steps to the same script as e
+// and running them with Exec interrupted when ctx is done, if Exec supports it.
+func (e *shellExecutor) WithContext(ctx context.Context) Executor {
+	ce, ok := e.Exec.(contextExecutor)
+	if !ok {
+		return e
+	}
+
+	e2 := *e
+	e2.Exec = ce.WithContext(ctx)
+
+	return &e2
+}
+
+// Unwrap returns the executor running the recorded steps, if any.
+func (e *shellExecutor) Unwrap() Executor {
+	return e.Exec
+}
+
+func (e *shellExecutor) Run(a Action, files StepFiles, env []string, cmdargs ...interface{}) error {
+	args := stringList(cmdargs...)
+
+	e.script.mu.Lock()
+	start := e.script.buf.Len()
+
+	if a.Objdir != "" {
+		e.mkdir(a.Objdir)
+	}
+
+	if dir := a.Package.Dir; dir != e.script.dir {
+		e.script.buf.WriteString("cd " + e.quote(dir) + "\n")
+		e.script.dir = dir
+	}
+
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i >= 0 {
+			e.script.buf.WriteString(kv[:i+1] + e.quote(kv[i+1:]) + " ")
+		}
+	}
+
+	for i, arg := range args {
+		if i > 0 {
+			e.script.buf.WriteByte(' ')
+		}
+		e.script.buf.WriteString(e.quote(arg))
+	}
+	e.script.buf.WriteByte('\n')
+	e.trace(start)
+	e.script.mu.Unlock()
+
+	if e.Exec != nil {
+		return e.Exec.Run(a, files, env, cmdargs...)
+	}
+
+	return nil
+}
+
+// WriteFile records content as a heredoc.
+// The shell always terminates the heredoc with a newline,
+// so content without a trailing newline gains one on replay.
+func (e *shellExecutor) WriteFile(path string, content []byte) error {
+	e.script.mu.Lock()
+	start := e.script.buf.Len()
+	e.mkdir(filepath.Dir(path) + string(filepath.Separator))
+
+	// Pick a delimiter that does not appear in the content.
+	delim := "EOF"
+	for bytes.Contains(content, []byte(delim)) {
+		delim += "_"
+	}
+
+	// The delimiter is unquoted, so the shell expands the references
+	// to the work directory in the content (eg. in importcfg files).
+	body := e.heredoc(string(content))
+
+	e.script.buf.WriteString("cat >" + e.quote(path) + " << " + delim + "\n")
+	e.script.buf.WriteString(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		e.script.buf.WriteByte('\n')
+	}
+	e.script.buf.WriteString(delim + "\n")
+	e.trace(start)
+	e.script.mu.Unlock()
+
+	if e.Exec != nil {
+		return e.Exec.WriteFile(path, content)
+	}
+
+	return nil
+}
+
+// WriteTo writes the recorded steps as a shell script to w.
+func (e *shellExecutor) WriteTo(w io.Writer) (int64, error) {
+	e.script.mu.Lock()
+	defer e.script.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	buf.WriteString("#!/bin/sh\n")
+	buf.WriteString("set -e\n")
+	buf.WriteString("WORK=${WORK:-$(mktemp -d)}\n")
+	buf.Write(e.script.buf.Bytes())
+
+	return buf.WriteTo(w)
+}
+
+// trace writes the script recorded since the offset start to Trace.
+func (e *shellExecutor) trace(start int) {
+	if e.Trace != nil {
+		e.Trace.Write(e.script.buf.Bytes()[start:])
+	}
+}
+
+func (e *shellExecutor) mkdir(dir string) {
+	if e.script.dirs[dir] {
+		return
+	}
+	e.script.dirs[dir] = true
+
+	e.script.buf.WriteString("mkdir -p " + e.quote(dir) + "\n")
+}
+
+// quote quotes s as a single shell word,
+// leaving references to the work directory expandable.
+func (e *shellExecutor) quote(s string) string {
+	if e.WorkDir != "" {
+		s = strings.Replace(s, e.WorkDir, "$WORK", -1)
+	}
+
+	parts := strings.Split(s, "$WORK")
+
+	var buf strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			if part != "" && isShellNameChar(part[0]) {
+				buf.WriteString("${WORK}")
+			} else {
+				buf.WriteString("$WORK")
+			}
+		}
+		if part != "" || len(parts) == 1 {
+			buf.WriteString(shellQuote(part))
+		}
+	}
+
+	return buf.String()
+}
+
+// heredoc escapes s for an unquoted heredoc,
+// leaving references to the work directory expandable.
+func (e *shellExecutor) heredoc(s string) string {
+	if e.WorkDir != "" {
+		s = strings.Replace(s, e.WorkDir, "$WORK", -1)
+	}
+
+	// Only \, ` and $ are special in an unquoted heredoc.
+	r := strings.NewReplacer(`\`, `\\`, "`", "\\`", "$", `\$`)
+
+	var buf strings.Builder
+	for i, part := range strings.Split(s, "$WORK") {
+		if i > 0 {
+			buf.WriteString("${WORK}")
+		}
+		buf.WriteString(r.Replace(part))
+	}
+
+	return buf.String()
+}
+
+// shellQuote quotes s for the shell if it contains any special characters.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	for i := 0; i < len(s); i++ {
+		if !isShellSafeChar(s[i]) {
+			return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+		}
+	}
+
+	return s
+}
+
+func isShellSafeChar(c byte) bool {
+	return isShellNameChar(c) || strings.IndexByte("@%+=:,./-", c) >= 0
+}
+
+func isShellNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
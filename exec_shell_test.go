@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s, quoted string
+	}{
+		{"", "''"},
+		{"abc", "abc"},
+		{"/usr/local/go/bin/go", "/usr/local/go/bin/go"},
+		{"-importcfg=a,b:c@d%e+f", "-importcfg=a,b:c@d%e+f"},
+		{"a b", "'a b'"},
+		{"$HOME", "'$HOME'"},
+		{"it's", `'it'\''s'`},
+		{"a\nb", "'a\nb'"},
+		{"dir=>", "'dir=>'"},
+	}
+
+	for _, test := range tests {
+		if got := shellQuote(test.s); got != test.quoted {
+			t.Errorf("shellQuote(%q) = %s, want %s", test.s, got, test.quoted)
+		}
+	}
+}
+
+func TestShellExecutorQuote(t *testing.T) {
+	tests := []struct {
+		workDir string
+		s       string
+		quoted  string
+	}{
+		{s: "$WORK", quoted: "$WORK"},
+		{s: "$WORK/b001/_pkg_.a", quoted: "$WORK/b001/_pkg_.a"},
+		{s: "$WORK/b001/a b.o", quoted: "$WORK'/b001/a b.o'"},
+		{s: "$WORK_1", quoted: "${WORK}_1"},
+		{s: "-trimpath=$WORK/b001=>", quoted: "-trimpath=$WORK'/b001=>'"},
+		{s: "$HOME", quoted: "'$HOME'"},
+		{workDir: "/tmp/gb-build1", s: "/tmp/gb-build1/b001/_pkg_.a", quoted: "$WORK/b001/_pkg_.a"},
+		{workDir: "/tmp/gb-build1", s: "/tmp/gb-build2", quoted: "/tmp/gb-build2"},
+	}
+
+	for _, test := range tests {
+		e := newShellExecutor(test.workDir, nil)
+		if got := e.quote(test.s); got != test.quoted {
+			t.Errorf("quote(%q) with work directory %q = %s, want %s", test.s, test.workDir, got, test.quoted)
+		}
+	}
+}
+
+// TestShellExecutorReplay replays the script of a build of two packages,
+// the second one reading the archive of the first through its importcfg,
+// as -n prints it.
+func TestShellExecutorReplay(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	action := func(path string, objdir string) Action {
+		return Action{
+			Package: Package{
+				Package: &build.Package{ImportPath: path, Dir: dir},
+			},
+			Objdir: "$WORK/" + objdir + "/",
+		}
+	}
+	a, b := action("example.com/a", "b001"), action("example.com/b", "b002")
+
+	// Special characters of heredocs are written as is,
+	// only references to the work directory are expanded.
+	const other = "`echo $HOME` \\$WORK \\\\ '$WORK'\n"
+
+	e := newShellExecutor("", nil)
+	steps := []func() error{
+		func() error {
+			return e.Run(a, StepFiles{}, nil, "sh", "-c", `echo a >"$1"`, "sh", "$WORK/b001/_pkg_.a")
+		},
+		func() error {
+			return e.WriteFile("$WORK/b002/importcfg", []byte("packagefile example.com/a=$WORK/b001/_pkg_.a\n"))
+		},
+		func() error {
+			return e.WriteFile("$WORK/b002/other", []byte(other))
+		},
+		func() error {
+			return e.Run(b, StepFiles{}, nil, "sh", "-c", `read -r _ f <"$1" && cp "${f#*=}" "$2"`, "sh", "$WORK/b002/importcfg", "$WORK/b002/_pkg_.a")
+		},
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var script bytes.Buffer
+	if _, err := e.WriteTo(&script); err != nil {
+		t.Fatal(err)
+	}
+
+	work := t.TempDir()
+	cmd := exec.Command("sh")
+	cmd.Stdin = &script
+	cmd.Env = append(os.Environ(), "WORK="+work)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"b002/importcfg", "packagefile example.com/a=" + work + "/b001/_pkg_.a\n"},
+		{"b002/other", "`echo $HOME` \\" + work + " \\\\ '" + work + "'\n"},
+		{"b002/_pkg_.a", "a\n"},
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(work, f.name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != f.content {
+			t.Errorf("%s = %q, want %q", f.name, data, f.content)
+		}
+	}
+}
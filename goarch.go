@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// goarchSetting returns the name and value of the microarchitecture
+// setting applying to ctx.GOARCH, eg. "GOAMD64" and "v1".
+// The name is empty if GOARCH has no such setting.
+func (ctx Context) goarchSetting() (key, value string) {
+	var def string
+
+	switch ctx.GOARCH {
+	case "amd64":
+		key, value, def = "GOAMD64", ctx.GOAMD64, "v1"
+	case "arm":
+		key, value, def = "GOARM", ctx.GOARM, "7"
+	case "386":
+		key, value, def = "GO386", ctx.GO386, "sse2"
+	case "mips", "mipsle":
+		key, value, def = "GOMIPS", ctx.GOMIPS, "hardfloat"
+	case "mips64", "mips64le":
+		key, value, def = "GOMIPS64", ctx.GOMIPS64, "hardfloat"
+	case "ppc64", "ppc64le":
+		key, value, def = "GOPPC64", ctx.GOPPC64, "power8"
+	default:
+		return "", ""
+	}
+
+	if value == "" {
+		value = os.Getenv(key)
+	}
+	if value == "" {
+		value = def
+	}
+
+	return key, value
+}
+
+// goarchEnv returns the environment telling the go tools the target
+// system and microarchitecture level to build for, rather than the
+// ones of the environment gb runs in.
+func (ctx Context) goarchEnv() []string {
+	env := []string{"GOOS=" + ctx.GOOS, "GOARCH=" + ctx.GOARCH}
+
+	key, value := ctx.goarchSetting()
+	if key == "" {
+		return env
+	}
+
+	return append(env, key+"="+value)
+}
+
+// goarchDefines returns the assembler flags defining the
+// microarchitecture level, as the go command does.
+func (ctx Context) goarchDefines() []string {
+	key, value := ctx.goarchSetting()
+
+	switch key {
+	case "GOAMD64", "GO386", "GOMIPS", "GOMIPS64":
+		// Define GOAMD64_value etc.
+		return []string{"-D", key + "_" + value}
+
+	case "GOPPC64":
+		// Define GOPPC64_power8..N.
+		// We treat each powerpc version as a superset of functionality.
+		var defines []string
+		for _, level := range ppc64Levels(value) {
+			defines = append([]string{"-D", "GOPPC64_power" + strconv.Itoa(level)}, defines...)
+		}
+		return defines
+
+	case "GOARM":
+		// Define GOARM_5..N. GOARM is either a version like "6",
+		// or a version and a FP mode, like "7,hardfloat".
+		var defines []string
+		for _, level := range armLevels(value) {
+			defines = append([]string{"-D", "GOARM_" + strconv.Itoa(level)}, defines...)
+		}
+		return defines
+	}
+
+	return nil
+}
+
+// goarchTags returns the build tags satisfied by the microarchitecture level,
+// eg. amd64.v1 and amd64.v2 for GOAMD64=v2.
+func (ctx Context) goarchTags() []string {
+	key, value := ctx.goarchSetting()
+
+	var tags []string
+
+	switch key {
+	case "GOAMD64":
+		level, _ := strconv.Atoi(strings.TrimPrefix(value, "v"))
+		for i := 1; i <= level; i++ {
+			tags = append(tags, ctx.GOARCH+".v"+strconv.Itoa(i))
+		}
+
+	case "GO386", "GOMIPS", "GOMIPS64":
+		tags = append(tags, ctx.GOARCH+"."+value)
+
+	case "GOPPC64":
+		for _, level := range ppc64Levels(value) {
+			tags = append(tags, ctx.GOARCH+".power"+strconv.Itoa(level))
+		}
+
+	case "GOARM":
+		for _, level := range armLevels(value) {
+			tags = append(tags, ctx.GOARCH+"."+strconv.Itoa(level))
+		}
+	}
+
+	return tags
+}
+
+// armLevels returns the ARM versions, from 5 up to the one GOARM selects.
+func armLevels(goarm string) []int {
+	version := 5
+	if goarm != "" && '6' <= goarm[0] && goarm[0] <= '7' {
+		version = int(goarm[0] - '0')
+	}
+
+	var levels []int
+	for i := 5; i <= version; i++ {
+		levels = append(levels, i)
+	}
+
+	return levels
+}
+
+// ppc64Levels returns the POWER versions, from 8 up to the one GOPPC64 selects.
+func ppc64Levels(goppc64 string) []int {
+	version, err := strconv.Atoi(strings.TrimPrefix(goppc64, "power"))
+	if err != nil || version < 8 {
+		version = 8
+	}
+
+	var levels []int
+	for i := 8; i <= version; i++ {
+		levels = append(levels, i)
+	}
+
+	return levels
+}
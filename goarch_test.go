@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGoarchDefinesAndTags(t *testing.T) {
+	tests := []struct {
+		ctx     Context
+		defines []string
+		tags    []string
+	}{
+		{
+			ctx:     Context{GOARCH: "amd64", GOAMD64: "v1"},
+			defines: []string{"-D", "GOAMD64_v1"},
+			tags:    []string{"amd64.v1"},
+		},
+		{
+			ctx:     Context{GOARCH: "amd64", GOAMD64: "v3"},
+			defines: []string{"-D", "GOAMD64_v3"},
+			tags:    []string{"amd64.v1", "amd64.v2", "amd64.v3"},
+		},
+		{
+			ctx:     Context{GOARCH: "386", GO386: "softfloat"},
+			defines: []string{"-D", "GO386_softfloat"},
+			tags:    []string{"386.softfloat"},
+		},
+		{
+			ctx:     Context{GOARCH: "arm", GOARM: "5"},
+			defines: []string{"-D", "GOARM_5"},
+			tags:    []string{"arm.5"},
+		},
+		{
+			ctx:     Context{GOARCH: "arm", GOARM: "7,hardfloat"},
+			defines: []string{"-D", "GOARM_7", "-D", "GOARM_6", "-D", "GOARM_5"},
+			tags:    []string{"arm.5", "arm.6", "arm.7"},
+		},
+		{
+			ctx:     Context{GOARCH: "mipsle", GOMIPS: "softfloat"},
+			defines: []string{"-D", "GOMIPS_softfloat"},
+			tags:    []string{"mipsle.softfloat"},
+		},
+		{
+			ctx:     Context{GOARCH: "mips64", GOMIPS64: "hardfloat"},
+			defines: []string{"-D", "GOMIPS64_hardfloat"},
+			tags:    []string{"mips64.hardfloat"},
+		},
+		{
+			ctx:     Context{GOARCH: "ppc64le", GOPPC64: "power8"},
+			defines: []string{"-D", "GOPPC64_power8"},
+			tags:    []string{"ppc64le.power8"},
+		},
+		{
+			ctx:     Context{GOARCH: "ppc64", GOPPC64: "power10"},
+			defines: []string{"-D", "GOPPC64_power10", "-D", "GOPPC64_power9", "-D", "GOPPC64_power8"},
+			tags:    []string{"ppc64.power8", "ppc64.power9", "ppc64.power10"},
+		},
+		{
+			ctx: Context{GOARCH: "riscv64"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.ctx.goarchDefines(); !reflect.DeepEqual(got, test.defines) {
+			t.Errorf("%s: goarchDefines() = %q, want %q", test.ctx.GOARCH, got, test.defines)
+		}
+		if got := test.ctx.goarchTags(); !reflect.DeepEqual(got, test.tags) {
+			t.Errorf("%s: goarchTags() = %q, want %q", test.ctx.GOARCH, got, test.tags)
+		}
+	}
+}
+
+func TestGoarchSettingDefault(t *testing.T) {
+	defer os.Setenv("GOARM", os.Getenv("GOARM"))
+
+	ctx := Context{GOOS: "linux", GOARCH: "arm"}
+
+	os.Setenv("GOARM", "")
+	if got := ctx.goarchEnv(); !reflect.DeepEqual(got, []string{"GOOS=linux", "GOARCH=arm", "GOARM=7"}) {
+		t.Errorf("goarchEnv() = %q with the default GOARM", got)
+	}
+
+	os.Setenv("GOARM", "6")
+	if got := ctx.goarchEnv(); !reflect.DeepEqual(got, []string{"GOOS=linux", "GOARCH=arm", "GOARM=6"}) {
+		t.Errorf("goarchEnv() = %q with $GOARM=6", got)
+	}
+
+	ctx.GOARM = "5"
+	if got := ctx.goarchEnv(); !reflect.DeepEqual(got, []string{"GOOS=linux", "GOARCH=arm", "GOARM=5"}) {
+		t.Errorf("goarchEnv() = %q with GOARM=5 and $GOARM=6", got)
+	}
+}
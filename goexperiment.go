@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+type goexperimentResult struct {
+	tags []string
+	err  error
+}
+
+var (
+	goexperimentsMu sync.Mutex
+	goexperiments   = make(map[[3]string]*goexperimentResult)
+)
+
+// goexperimentTags returns the goexperiment.* build tags of the experiments
+// the Go toolchain at ctx.GoTool enables for ctx.GOOS and ctx.GOARCH,
+// including the ones selected by $GOEXPERIMENT.
+// Releases before Go 1.17 have no such tags.
+func (ctx Context) goexperimentTags() ([]string, error) {
+	v, err := ctx.goVersion()
+	if err != nil {
+		return nil, err
+	}
+	if !v.atLeast(17) {
+		return nil, nil
+	}
+
+	goexperimentsMu.Lock()
+	defer goexperimentsMu.Unlock()
+
+	key := [3]string{ctx.GoTool, ctx.GOOS, ctx.GOARCH}
+	r, ok := goexperiments[key]
+	if !ok {
+		tags, err := probeGoexperimentTags(ctx.GoTool, ctx.GOOS, ctx.GOARCH)
+		r = &goexperimentResult{tags, err}
+		goexperiments[key] = r
+	}
+
+	return r.tags, r.err
+}
+
+// probeGoexperimentTags runs the go command to list the tool tags of a target
+// and returns the goexperiment.* ones.
+func probeGoexperimentTags(gotool string, goos string, goarch string) ([]string, error) {
+	// Listing a standard package outside of any module
+	// is unaffected by the module the build runs in.
+	cmd := exec.Command(gotool, "list", "-f", "{{join context.ToolTags \" \"}}", "runtime")
+	cmd.Dir = os.TempDir()
+	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "GO111MODULE=off", "GOFLAGS=")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s list: %v", gotool, err)
+	}
+
+	var tags []string
+	for _, tag := range strings.Fields(string(out)) {
+		if strings.HasPrefix(tag, "goexperiment.") {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+type goToolDirResult struct {
+	dir string
+	err error
+}
+
+var (
+	goToolDirsMu sync.Mutex
+	goToolDirs   = make(map[string]*goToolDirResult)
+)
+
+// goToolDir returns the directory of the tools of the Go toolchain at ctx.GoTool
+// (eg. the compiler and the linker), probed once per GoTool.
+func (ctx Context) goToolDir() (string, error) {
+	goToolDirsMu.Lock()
+	defer goToolDirsMu.Unlock()
+
+	r, ok := goToolDirs[ctx.GoTool]
+	if !ok {
+		dir, err := probeGoToolDir(ctx.GoTool)
+		r = &goToolDirResult{dir, err}
+		goToolDirs[ctx.GoTool] = r
+	}
+
+	return r.dir, r.err
+}
+
+// probeGoToolDir runs the go command to determine its tool directory.
+func probeGoToolDir(gotool string) (string, error) {
+	out, err := exec.Command(gotool, "env", "GOTOOLDIR").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s env GOTOOLDIR: %v", gotool, err)
+	}
+
+	dir := string(bytes.TrimSpace(out))
+	if dir == "" {
+		return "", fmt.Errorf("%s env GOTOOLDIR: empty output", gotool)
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// loadActions imports the package at path and every package it imports,
+// and returns the actions building them, dependencies first.
+//
+// Each action gets its own object directory in workDir.
+func loadActions(ctx Context, path string, srcDir string, workDir string) ([]*Action, error) {
+	bctx, err := ctx.buildContext()
+	if err != nil {
+		return nil, err
+	}
+
+	mods := newModLoader(ctx, bctx)
+	if err := mods.loadMain(srcDir); err != nil {
+		return nil, err
+	}
+
+	var actions []*Action
+
+	loaded := make(map[string]*Action)
+
+	// stack is the chain of imports currently being loaded.
+	var stack []string
+
+	var load func(path string, srcDir string) (*Action, error)
+	load = func(path string, srcDir string) (*Action, error) {
+		pkg, m, err := mods.importPackage(bctx, path, srcDir)
+		if err != nil {
+			return nil, err
+		}
+
+		// The gccgo installation provides the standard packages.
+		if pkg.Goroot && bctx.Compiler == "gccgo" {
+			return nil, nil
+		}
+
+		if a, ok := loaded[pkg.ImportPath]; ok {
+			if a.Objdir == "" {
+				return nil, importCycleError(append(stack, pkg.ImportPath))
+			}
+
+			return a, nil
+		}
+
+		a := &Action{
+			Package: Package{
+				Package: pkg,
+			},
+		}
+		if m != nil {
+			a.Package.ModulePath = m.Path
+			a.Package.ModuleVersion = m.Version
+			a.Package.ModuleGoVersion = m.GoVersion
+		}
+		loaded[pkg.ImportPath] = a
+
+		stack = append(stack, pkg.ImportPath)
+		for _, imp := range pkg.Imports {
+			if imp == "C" || imp == "unsafe" {
+				continue
+			}
+
+			dep, err := load(imp, pkg.Dir)
+			if err != nil {
+				return nil, err
+			}
+			if dep == nil {
+				continue
+			}
+
+			if dep.Package.ImportPath != imp {
+				if a.Package.ImportMap == nil {
+					a.Package.ImportMap = make(map[string]string)
+				}
+				a.Package.ImportMap[imp] = dep.Package.ImportPath
+			}
+
+			a.Deps = append(a.Deps, dep)
+		}
+
+		// Cgo translation adds imports of "runtime/cgo" and "syscall",
+		// except for certain packages, to avoid circular dependencies.
+		if len(pkg.CgoFiles) > 0 {
+			for _, imp := range cgoImports(bctx, pkg) {
+				if importsPath(a, imp) {
+					continue
+				}
+
+				dep, err := load(imp, pkg.Dir)
+				if err != nil {
+					return nil, err
+				}
+				if dep == nil {
+					continue
+				}
+
+				a.Deps = append(a.Deps, dep)
+			}
+		}
+
+		// Executables link the runtime even if the main package does not import it.
+		if pkg.Name == "main" && !importsPath(a, "runtime") {
+			dep, err := load("runtime", pkg.Dir)
+			if err != nil {
+				return nil, err
+			}
+
+			if dep != nil {
+				a.Deps = append(a.Deps, dep)
+			}
+		}
+		stack = stack[:len(stack)-1]
+
+		// The object directory is assigned once all dependencies are loaded,
+		// which marks the action as complete.
+		a.Objdir = filepath.Join(workDir, fmt.Sprintf("b%03d", len(actions)+1)) + string(filepath.Separator)
+		a.Importcfg = a.Objdir + "importcfg"
+		actions = append(actions, a)
+
+		return a, nil
+	}
+
+	a, err := load(path, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, fmt.Errorf("package %s is provided by the %s installation", path, bctx.Compiler)
+	}
+
+	return actions, nil
+}
+
+// importsPath reports whether a depends directly on the package path.
+func importsPath(a *Action, path string) bool {
+	for _, dep := range a.Deps {
+		if dep.Package.ImportPath == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cgoImports returns the packages imported by the code cgo generates for pkg.
+func cgoImports(bctx *build.Context, pkg *build.Package) []string {
+	var imports []string
+
+	if (!pkg.Goroot || pkg.ImportPath != "runtime/cgo") && bctx.Compiler != "gccgo" {
+		imports = append(imports, "runtime/cgo")
+	}
+
+	switch pkg.ImportPath {
+	case "runtime/cgo", "runtime/race", "runtime/msan", "runtime/asan":
+		if pkg.Goroot {
+			break
+		}
+		fallthrough
+	default:
+		imports = append(imports, "syscall")
+	}
+
+	return imports
+}
+
+// importCycleError reports an import cycle.
+// The last package in stack is the one imported again.
+func importCycleError(stack []string) error {
+	// Only report the packages that are part of the cycle.
+	for i, path := range stack {
+		if path == stack[len(stack)-1] {
+			stack = stack[i:]
+			break
+		}
+	}
+
+	var buf strings.Builder
+
+	buf.WriteString("import cycle not allowed")
+	for i, path := range stack {
+		if i == 0 {
+			buf.WriteString("\npackage " + path)
+		} else {
+			buf.WriteString("\n\timports " + path)
+		}
+	}
+
+	return errors.New(buf.String())
+}
+
+// BuildAll builds actions in order.
+// Actions must be ordered so that every action comes after its dependencies.
+func BuildAll(ctx Context, exec Executor, t Toolchain, actions []*Action) error {
+	for _, a := range actions {
+		if err := Build(ctx, exec, t, *a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestImportCycleError(t *testing.T) {
+	tests := []struct {
+		stack []string
+		err   string
+	}{
+		{
+			stack: []string{"a", "a"},
+			err:   "import cycle not allowed\npackage a\n\timports a",
+		},
+		{
+			stack: []string{"a", "b", "c", "a"},
+			err:   "import cycle not allowed\npackage a\n\timports b\n\timports c\n\timports a",
+		},
+		{
+			stack: []string{"main", "a", "b", "a"},
+			err:   "import cycle not allowed\npackage a\n\timports b\n\timports a",
+		},
+	}
+
+	for _, test := range tests {
+		if err := importCycleError(test.stack); err.Error() != test.err {
+			t.Errorf("importCycleError(%q) = %q, want %q", test.stack, err, test.err)
+		}
+	}
+}
+
+func TestLoadActionsCycle(t *testing.T) {
+	ctx := testContext(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/p\n\ngo 1.20\n",
+		"main.go": "package main\n\nimport _ \"example.com/p/a\"\n\nfunc main() {}\n",
+		"a/a.go":  "package a\n\nimport _ \"example.com/p/b\"\n",
+		"b/b.go":  "package b\n\nimport _ \"example.com/p/a\"\n",
+	})
+
+	_, err := loadActions(ctx, ".", dir, "$WORK")
+
+	want := "import cycle not allowed\npackage example.com/p/a\n\timports example.com/p/b\n\timports example.com/p/a"
+	if err == nil || err.Error() != want {
+		t.Errorf("loadActions: error %v, want %q", err, want)
+	}
+}
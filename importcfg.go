@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// importcfg returns the import configuration of the compiler for an action.
+//
+// It maps the imports of the package to the archives built by its dependencies.
+func importcfg(a Action) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# import config\n")
+
+	var paths []string
+	for path := range a.Package.ImportMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "importmap %s=%s\n", path, a.Package.ImportMap[path])
+	}
+
+	for _, dep := range a.Deps {
+		fmt.Fprintf(&buf, "packagefile %s=%s\n", dep.Package.ImportPath, dep.archive())
+	}
+
+	return buf.Bytes()
+}
+
+// linkImportcfg returns the import configuration of the linker for an action.
+//
+// It maps every package in the transitive closure of the action's dependencies to its archive.
+func linkImportcfg(a Action) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# import config\n")
+
+	for _, dep := range a.deps(true) {
+		fmt.Fprintf(&buf, "packagefile %s=%s\n", dep.Package.ImportPath, dep.archive())
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Link links the executable built from a main package action to out.
+// The action and its dependencies must be built first.
+func Link(ctx Context, exec Executor, t Toolchain, a Action, out string) error {
+	if a.Package.Name != "main" {
+		return fmt.Errorf("%s: cannot link non-main package %s", a.Package.ImportPath, a.Package.Name)
+	}
+
+	importcfg := a.Objdir + "importcfg.link"
+	if err := exec.WriteFile(importcfg, linkImportcfg(a)); err != nil {
+		return err
+	}
+
+	return t.Ld(ctx, exec, a, out, importcfg, a.archive())
+}
@@ -1,37 +1,225 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"go/build"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func main() {
+	// Builds run gb to check files generated by their steps (see checkCgoLdflagsStep).
+	if len(os.Args) > 1 && os.Args[1] == checkCgoLdflagsCmd {
+		if err := runCheckCgoLdflags(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run builds the package named on the command line as the flags say.
+func run() error {
+	var (
+		dryRun = flag.Bool("n", false, "print the commands but do not run them")
+		trace  = flag.Bool("x", false, "print the commands")
+		plan   = flag.Bool("plan", false, "print the build steps as JSON but do not run them")
+		ninja  = flag.String("ninja", "", "write a Ninja build file to `file` instead of building")
+		mkfile = flag.String("makefile", "", "write a Makefile to `file` instead of building")
+		output = flag.String("o", "", "write the resulting executable to `file`")
+		procs  = flag.Int("p", 0, "build up to `n` packages in parallel (defaults to GOMAXPROCS)")
+		comp   = flag.String("compiler", "gc", "build with the `name` toolchain ("+strings.Join(Toolchains(), ", ")+")")
+		tags   = flag.String("tags", "", "a comma-separated `list` of additional build tags")
+		mod    = flag.String("mod", "", "import required modules from the vendor directory (vendor) or the module cache (mod)")
+		trim   = flag.Bool("trimpath", true, "record source paths relative to modules rather than real paths")
+	)
+	flag.Parse()
+
 	ctx := Context{
 		GOROOT: build.Default.GOROOT,
 		GOOS:   build.Default.GOOS,
 		GOARCH: build.Default.GOARCH,
-		GoTool: "",
+		GoTool: filepath.Join(build.Default.GOROOT, "bin", "go"),
+
+		CgoEnabled: build.Default.CgoEnabled,
+		BuildTags:  splitTags(*tags),
+
+		Mod: *mod,
+
+		Compiler: *comp,
+	}
+
+	if !*trim {
+		ctx.Trimpath = TrimpathOff
+	}
+
+	toolchain, err := NewToolchain(ctx)
+	if err != nil {
+		return err
 	}
 
-	pkg, err := build.Default.Import(os.Args[1], ".", 0)
+	cwd, err := os.Getwd()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	action := Action{
-		Package: Package{
-			Package:       pkg,
-			ModulePath:    "",
-			ModuleVersion: "",
-		},
-		Objdir:    "",
-		Importcfg: "",
+	if *ninja != "" || *mkfile != "" {
+		name := *ninja
+		if name == "" {
+			name = *mkfile
+		}
+
+		file, err := filepath.Abs(name)
+		if err != nil {
+			return err
+		}
+
+		actions, err := loadActions(ctx, flag.Arg(0), cwd, filepath.Join(filepath.Dir(file), "gb-work"))
+		if err != nil {
+			return err
+		}
+
+		out := outputFile(actions, *output, cwd)
+
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if *ninja != "" {
+			err = Ninja(f, ctx, toolchain, actions, out)
+		} else {
+			err = Makefile(f, ctx, toolchain, actions, out)
+		}
+
+		return err
 	}
 
-	toolchain := gcToolchain{}
+	work := "$WORK"
+	if !*dryRun && !*plan {
+		work, err = ioutil.TempDir("", "gb-build")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(work)
+	}
 
-	err = Build(ctx, nil, toolchain, action)
+	actions, err := loadActions(ctx, flag.Arg(0), cwd, work)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	root := actions[len(actions)-1]
+	if *output != "" && root.Package.Name != "main" {
+		return errors.New("-o requires a main package")
+	}
+	out := outputFile(actions, *output, cwd)
+
+	if *plan {
+		var steps []Step
+		for _, a := range actions {
+			s, err := Plan(ctx, toolchain, *a)
+			if err != nil {
+				return err
+			}
+
+			steps = append(steps, s...)
+		}
+
+		if root.Package.Name == "main" {
+			var exec planExecutor
+			if err := Link(ctx, &exec, toolchain, *root, out); err != nil {
+				return err
+			}
+
+			steps = append(steps, exec.steps...)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+
+		return enc.Encode(steps)
+	}
+
+	if *dryRun {
+		exec := newShellExecutor("", nil)
+
+		err = BuildAll(ctx, exec, toolchain, actions)
+		if err == nil && root.Package.Name == "main" {
+			err = Link(ctx, exec, toolchain, *root, out)
+		}
+		if err != nil {
+			return err
+		}
+
+		exec.WriteTo(os.Stdout)
+
+		return nil
+	}
+
+	local := newLocalExecutor("")
+
+	var exec Executor = local
+	if *trace {
+		fmt.Fprintf(os.Stderr, "WORK=%s\n", work)
+
+		script := newShellExecutor(work, local)
+		script.Trace = os.Stderr
+		exec = script
+	}
+
+	err = BuildParallel(ctx, exec, toolchain, actions, *procs)
+	if err == nil && root.Package.Name == "main" {
+		err = Link(ctx, exec, toolchain, *root, out)
 	}
+
+	// Print the output in a deterministic order, regardless of the order actions ran in.
+	// The output of the failed step is part of its error.
+	for _, a := range actions {
+		os.Stderr.Write(local.Output(*a))
+	}
+
+	return err
+}
+
+// outputFile returns the path the executable built from actions is written to.
+// It is made absolute against dir, since the linker runs in the package
+// directory. It is empty if the last action does not build a main package.
+func outputFile(actions []*Action, output string, dir string) string {
+	root := actions[len(actions)-1]
+	if root.Package.Name != "main" {
+		return ""
+	}
+
+	if output == "" {
+		output = filepath.Base(root.Package.Dir)
+	}
+
+	return mkAbs(dir, output)
+}
+
+// splitTags splits the comma-separated list of build tags s.
+func splitTags(s string) []string {
+	var tags []string
+
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Makefile writes a GNU Makefile building actions to w.
+// Actions must be ordered so that every action comes after its dependencies.
+//
+// Every package archive is a target depending on the package sources
+// and the archives of its dependencies.
+// If the last action builds a main package and out is not empty,
+// out is a target linking the executable.
+func Makefile(w io.Writer, ctx Context, t Toolchain, actions []*Action, out string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# Generated by gb. DO NOT EDIT.\n\n")
+	fmt.Fprintf(bw, ".DELETE_ON_ERROR:\n\n")
+
+	if len(actions) == 0 {
+		return bw.Flush()
+	}
+
+	root := actions[len(actions)-1]
+
+	link := out != "" && root.Package.Name == "main"
+	if link {
+		fmt.Fprintf(bw, "all: %s\n\n", makePath(out))
+	} else {
+		fmt.Fprintf(bw, "all: %s\n\n", makePath(root.archive()))
+	}
+	fmt.Fprintf(bw, ".PHONY: all\n\n")
+
+	for _, a := range actions {
+		steps, err := Plan(ctx, t, *a)
+		if err != nil {
+			return err
+		}
+
+		writeMakeRule(bw, a.archive(), a.Objdir, steps)
+	}
+
+	if link {
+		var exec planExecutor
+		if err := Link(ctx, &exec, t, *root, out); err != nil {
+			return err
+		}
+
+		writeMakeRule(bw, out, "", exec.steps)
+	}
+
+	return bw.Flush()
+}
+
+// writeMakeRule writes a rule running steps to make target.
+// Inputs of the steps that are not produced by the steps themselves are prerequisites of the target.
+func writeMakeRule(w io.Writer, target string, objdir string, steps []Step) {
+	produced := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	var prereqs []string
+	for _, s := range steps {
+		for _, in := range s.Inputs {
+			if in == "" || produced[in] || seen[in] {
+				continue
+			}
+			seen[in] = true
+
+			prereqs = append(prereqs, makePath(in))
+		}
+
+		for _, out := range s.Outputs {
+			produced[out] = true
+		}
+	}
+
+	fmt.Fprintf(w, "%s:", makePath(target))
+	for _, p := range prereqs {
+		fmt.Fprintf(w, " \\\n\t\t%s", p)
+	}
+	fmt.Fprintf(w, "\n")
+
+	if objdir != "" {
+		fmt.Fprintf(w, "\tmkdir -p %s\n", makeEscape(shellQuote(objdir)))
+	}
+	for _, s := range steps {
+		fmt.Fprintf(w, "\t%s\n", makeEscape(s.command()))
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// makeEscape escapes s for use in a recipe.
+func makeEscape(s string) string {
+	return strings.Replace(s, "$", "$$", -1)
+}
+
+// makePath escapes a path for use in a target or prerequisite list.
+func makePath(p string) string {
+	r := strings.NewReplacer("$", "$$", " ", `\ `, ":", `\:`, "#", `\#`)
+
+	return r.Replace(p)
+}
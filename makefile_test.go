@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteMakeRule(t *testing.T) {
+	steps := []Step{
+		{File: "$WORK/b002/importcfg", Content: "packagefile example.com/dep=$WORK/b001/_pkg_.a\n", Outputs: []string{"$WORK/b002/importcfg"}},
+		{
+			Dir:     "/src/my p",
+			Args:    []string{"go", "tool", "compile", "-o", "$WORK/b002/_pkg_.a", "./p.go"},
+			Inputs:  []string{"$WORK/b002/importcfg", "$WORK/b001/_pkg_.a", "/src/my p/p.go"},
+			Outputs: []string{"$WORK/b002/_pkg_.a"},
+		},
+		{
+			Dir:     "/src/my p",
+			Args:    []string{"go", "tool", "pack", "r", "$WORK/b002/_pkg_.a", "$WORK/b002/x.o"},
+			Inputs:  []string{"$WORK/b002/_pkg_.a", "$WORK/b001/_pkg_.a", "/src/my p/c:#.h"},
+			Outputs: []string{"$WORK/b002/_pkg_.a"},
+		},
+	}
+
+	var buf bytes.Buffer
+	writeMakeRule(&buf, "$WORK/b002/_pkg_.a", "$WORK/b002/", steps)
+
+	want := `$$WORK/b002/_pkg_.a: \
+		$$WORK/b001/_pkg_.a \
+		/src/my\ p/p.go \
+		/src/my\ p/c\:\#.h
+	mkdir -p '$$WORK/b002/'
+	printf '%b' 'packagefile example.com/dep=$$WORK/b001/_pkg_.a\n' > '$$WORK/b002/importcfg'
+	cd '/src/my p' && go tool compile -o '$$WORK/b002/_pkg_.a' ./p.go
+	cd '/src/my p' && go tool pack r '$$WORK/b002/_pkg_.a' '$$WORK/b002/x.o'
+
+`
+	if got := buf.String(); got != want {
+		t.Errorf("rule:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMakefile(t *testing.T) {
+	ctx := testContext(t)
+	dir, actions := loadTestActions(t, ctx, map[string]string{
+		"go.mod":     "module example.com/p\n\ngo 1.20\n",
+		"dep/dep.go": "package dep\n",
+		"main.go":    "package main\n\nimport _ \"example.com/p/dep\"\n\nfunc main() {}\n",
+	}, ".")
+	out := filepath.Join(dir, "p")
+
+	var buf bytes.Buffer
+	if err := Makefile(&buf, ctx, gcToolchain{}, actions, out); err != nil {
+		t.Fatal(err)
+	}
+	makefile := buf.String()
+
+	if !strings.Contains(makefile, "\nall: "+makePath(out)+"\n") {
+		t.Errorf("the all target does not build %s:\n%s", out, makefile)
+	}
+
+	// Every package archive and the executable are targets,
+	// depending on the archives of the packages they import.
+	dep := findAction(t, actions, "example.com/p/dep")
+	root := actions[len(actions)-1]
+	targets := []struct {
+		target string
+		prereq string
+	}{
+		{makePath(dep.archive()), makePath(filepath.Join(dir, "dep", "dep.go"))},
+		{makePath(root.archive()), makePath(dep.archive())},
+		{makePath(out), makePath(root.archive())},
+	}
+	for _, a := range actions {
+		targets = append(targets, struct{ target, prereq string }{target: makePath(a.archive())})
+	}
+	for _, target := range targets {
+		i := strings.Index(makefile, "\n"+target.target+":")
+		if i < 0 {
+			t.Errorf("no rule makes %s", target.target)
+			continue
+		}
+		rule := makefile[i+1:]
+		rule = rule[:strings.Index(rule, "\n\n")+1]
+		if target.prereq != "" && !strings.Contains(rule, "\t\t"+target.prereq+" \\\n") && !strings.Contains(rule, "\t\t"+target.prereq+"\n") {
+			t.Errorf("%s does not depend on %s:\n%s", target.target, target.prereq, rule)
+		}
+	}
+
+	// The Makefile is valid.
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip(err)
+	}
+	cmd := exec.Command("make", "-n", "-f", "-")
+	cmd.Dir = dir
+	cmd.Stdin = &buf
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("make -n: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// modFile is a parsed go.mod file.
+// Only the directives affecting builds are kept.
+type modFile struct {
+	Module  string
+	Go      string
+	Require []modVersion
+	Exclude []modVersion
+	Replace []modReplace
+}
+
+// modVersion is a version of a module.
+// The version of a replacement by a directory is empty.
+type modVersion struct {
+	Path    string
+	Version string
+}
+
+// modReplace is a replace directive.
+// If Old.Version is empty, every version of the module is replaced.
+type modReplace struct {
+	Old modVersion
+	New modVersion
+}
+
+// parseModFile parses the go.mod file named file.
+func parseModFile(file string, data []byte) (*modFile, error) {
+	lines, err := modLines(file, data)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &modFile{}
+
+	for _, l := range lines {
+		switch l.verb() {
+		case "module":
+			if len(l.args) != 1 {
+				return nil, l.errorf("usage: module module/path")
+			}
+			f.Module = l.args[0]
+
+		case "go":
+			if len(l.args) != 1 {
+				return nil, l.errorf("usage: go 1.23")
+			}
+			f.Go = l.args[0]
+
+		case "require", "exclude":
+			if len(l.args) != 2 {
+				return nil, l.errorf("usage: %s module/path v1.2.3", l.verb())
+			}
+			v := modVersion{l.args[0], l.args[1]}
+			if l.verb() == "require" {
+				f.Require = append(f.Require, v)
+			} else {
+				f.Exclude = append(f.Exclude, v)
+			}
+
+		case "replace":
+			r, err := parseReplace(l)
+			if err != nil {
+				return nil, err
+			}
+			f.Replace = append(f.Replace, r)
+		}
+	}
+
+	if f.Module == "" {
+		return nil, fmt.Errorf("%s: no module declaration", file)
+	}
+
+	return f, nil
+}
+
+// parseReplace parses the directive "replace old [v] => new [v]".
+func parseReplace(l modLine) (modReplace, error) {
+	var r modReplace
+
+	arrow := 2
+	if len(l.args) >= 2 && l.args[1] == "=>" {
+		arrow = 1
+	}
+	if len(l.args) < arrow+2 || len(l.args) > arrow+3 || l.args[arrow] != "=>" {
+		return r, l.errorf("usage: replace module/path [v1.2.3] => other/module v1.4\n\t or replace module/path [v1.2.3] => ../local/directory")
+	}
+
+	r.Old.Path = l.args[0]
+	if arrow == 2 {
+		r.Old.Version = l.args[1]
+	}
+
+	r.New.Path = l.args[arrow+1]
+	if len(l.args) == arrow+3 {
+		r.New.Version = l.args[arrow+2]
+	} else if !isLocalModPath(r.New.Path) {
+		return r, l.errorf("replacement module without version must be directory path (rooted or starting with ./ or ../)")
+	}
+
+	return r, nil
+}
+
+// isLocalModPath reports whether the replacement path is a directory.
+func isLocalModPath(path string) bool {
+	return strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") || path == "." || path == ".." ||
+		strings.HasPrefix(path, "/") || strings.HasPrefix(path, `.\`) || strings.HasPrefix(path, `..\`) ||
+		len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+}
+
+// modLine is a directive of a go.mod or go.work file.
+type modLine struct {
+	file string
+	line int
+
+	// Tokens are the verb and the arguments of the directive.
+	tokens []string
+	args   []string
+}
+
+func (l modLine) verb() string {
+	return l.tokens[0]
+}
+
+func (l modLine) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", l.file, l.line, fmt.Sprintf(format, args...))
+}
+
+// modLines splits a go.mod or go.work file into its directives.
+// Directives of a block, eg. require ( ... ), are returned as if
+// each was written on a line of its own.
+func modLines(file string, data []byte) ([]modLine, error) {
+	var lines []modLine
+
+	block := ""
+	blockLine := 0
+
+	for i, text := range strings.Split(string(data), "\n") {
+		n := i + 1
+
+		tokens, err := modTokens(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, n, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		switch {
+		case block != "" && len(tokens) == 1 && tokens[0] == ")":
+			block = ""
+			continue
+
+		case block != "":
+			tokens = append([]string{block}, tokens...)
+
+		case len(tokens) == 2 && tokens[1] == "(":
+			block = tokens[0]
+			blockLine = n
+			continue
+
+		case len(tokens) == 3 && tokens[1] == "(" && tokens[2] == ")":
+			continue
+		}
+
+		lines = append(lines, modLine{file: file, line: n, tokens: tokens, args: tokens[1:]})
+	}
+
+	if block != "" {
+		return nil, fmt.Errorf("%s:%d: unterminated %s block", file, blockLine, block)
+	}
+
+	return lines, nil
+}
+
+// modTokens splits a line of a go.mod or go.work file into tokens,
+// unquoting quoted strings and dropping comments.
+func modTokens(line string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(line); {
+		c := line[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case strings.HasPrefix(line[i:], "//"):
+			return tokens, nil
+
+		case c == '(' || c == ')':
+			tokens = append(tokens, line[i:i+1])
+			i++
+
+		case strings.HasPrefix(line[i:], "=>"):
+			tokens = append(tokens, "=>")
+			i += 2
+
+		case c == '"' || c == '`':
+			j := i + 1
+			for j < len(line) && line[j] != c {
+				if c == '"' && line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted string")
+			}
+
+			s, err := strconv.Unquote(line[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line[i:j+1])
+			}
+			tokens = append(tokens, s)
+			i = j + 1
+
+		default:
+			j := i
+			for j < len(line) && strings.IndexByte(" \t\r()\"`", line[j]) < 0 && !strings.HasPrefix(line[j:], "//") && !strings.HasPrefix(line[j:], "=>") {
+				j++
+			}
+			tokens = append(tokens, line[i:j])
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModTokens(t *testing.T) {
+	tests := []struct {
+		line   string
+		tokens []string
+		err    string
+	}{
+		{line: "", tokens: nil},
+		{line: "// comment", tokens: nil},
+		{line: "module example.com/m", tokens: []string{"module", "example.com/m"}},
+		{line: "require example.com/a v1.0.0 // indirect", tokens: []string{"require", "example.com/a", "v1.0.0"}},
+		{line: "require (", tokens: []string{"require", "("}},
+		{line: "\t)", tokens: []string{")"}},
+		{line: "exclude ()", tokens: []string{"exclude", "(", ")"}},
+		{line: `module "example.com/quoted"`, tokens: []string{"module", "example.com/quoted"}},
+		{line: "module `example.com/raw`", tokens: []string{"module", "example.com/raw"}},
+		{line: `replace "a b" => "./dir\twith tab"`, tokens: []string{"replace", "a b", "=>", "./dir\twith tab"}},
+		{line: "replace a=>./b", tokens: []string{"replace", "a", "=>", "./b"}},
+		{line: "replace a v1.0.0=>b v1.1.0", tokens: []string{"replace", "a", "v1.0.0", "=>", "b", "v1.1.0"}},
+		{line: `module "example.com/m`, err: "unterminated quoted string"},
+		{line: `module "\q"`, err: "invalid quoted string"},
+	}
+
+	for _, test := range tests {
+		tokens, err := modTokens(test.line)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("modTokens(%q): error %v, want %q", test.line, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("modTokens(%q): %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("modTokens(%q) = %q, want %q", test.line, tokens, test.tokens)
+		}
+	}
+}
+
+func TestParseModFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		file *modFile
+		err  string
+	}{
+		{
+			name: "directives",
+			data: `module example.com/m
+
+go 1.17
+
+require example.com/a v1.0.0
+exclude example.com/a v0.9.0
+`,
+			file: &modFile{
+				Module:  "example.com/m",
+				Go:      "1.17",
+				Require: []modVersion{{"example.com/a", "v1.0.0"}},
+				Exclude: []modVersion{{"example.com/a", "v0.9.0"}},
+			},
+		},
+		{
+			name: "blocks",
+			data: `module example.com/m
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.2.0 // indirect
+)
+
+exclude (
+)
+
+replace (
+	example.com/a => ./a
+	example.com/b v1.2.0 => example.com/c v1.3.0
+)
+`,
+			file: &modFile{
+				Module: "example.com/m",
+				Require: []modVersion{
+					{"example.com/a", "v1.0.0"},
+					{"example.com/b", "v1.2.0"},
+				},
+				Replace: []modReplace{
+					{Old: modVersion{Path: "example.com/a"}, New: modVersion{Path: "./a"}},
+					{Old: modVersion{"example.com/b", "v1.2.0"}, New: modVersion{"example.com/c", "v1.3.0"}},
+				},
+			},
+		},
+		{
+			name: "replace",
+			data: `module example.com/m
+replace example.com/a => example.com/b v1.0.0
+replace example.com/a v1.0.0 => ../a
+replace example.com/a v1.1.0 => /abs/a
+`,
+			file: &modFile{
+				Module: "example.com/m",
+				Replace: []modReplace{
+					{Old: modVersion{Path: "example.com/a"}, New: modVersion{"example.com/b", "v1.0.0"}},
+					{Old: modVersion{"example.com/a", "v1.0.0"}, New: modVersion{Path: "../a"}},
+					{Old: modVersion{"example.com/a", "v1.1.0"}, New: modVersion{Path: "/abs/a"}},
+				},
+			},
+		},
+		{
+			name: "no module",
+			data: "go 1.17\n",
+			err:  "go.mod: no module declaration",
+		},
+		{
+			name: "unterminated block",
+			data: "module example.com/m\nrequire (\n\texample.com/a v1.0.0\n",
+			err:  "go.mod:2: unterminated require block",
+		},
+		{
+			name: "require without version",
+			data: "module example.com/m\nrequire example.com/a\n",
+			err:  "go.mod:2: usage: require module/path v1.2.3",
+		},
+		{
+			name: "replacement module without version",
+			data: "module example.com/m\nreplace example.com/a => example.com/b\n",
+			err:  "go.mod:2: replacement module without version must be directory path",
+		},
+		{
+			name: "replace without arrow",
+			data: "module example.com/m\nreplace example.com/a v1.0.0 ./a\n",
+			err:  "go.mod:2: usage: replace",
+		},
+	}
+
+	for _, test := range tests {
+		f, err := parseModFile("go.mod", []byte(test.data))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(f, test.file) {
+			t.Errorf("%s: got %+v, want %+v", test.name, f, test.file)
+		}
+	}
+}
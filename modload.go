@@ -0,0 +1,562 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// loadMain finds the main modules of a build run in the directory dir:
+// the modules used by the go.work file of the workspace, if any, or else
+// the module containing dir. Without main modules, packages are imported
+// from GOPATH.
+func (ml *modLoader) loadMain(dir string) error {
+	file, err := ml.findWorkFile(dir)
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		return ml.loadWorkspace(file)
+	}
+
+	m, err := ml.moduleOf(dir)
+	if err != nil {
+		return err
+	}
+
+	if m != nil {
+		ml.main = append(ml.main, m)
+	}
+
+	ml.vendor, err = ml.loadVendor(ml.mod)
+
+	return err
+}
+
+// importPackage imports the package at path, imported by a package in srcDir,
+// and returns the module providing it. Standard packages have no module.
+//
+// Import paths are resolved against the main module and the modules it
+// requires, as the go command does, but without running it: required
+// modules have to be in the module cache already.
+func (ml *modLoader) importPackage(bctx *build.Context, path string, srcDir string) (*build.Package, *module, error) {
+	if len(ml.main) == 0 {
+		pkg, err := bctx.Import(path, srcDir, 0)
+		return pkg, nil, err
+	}
+
+	dir, importPath, m, err := ml.resolve(bctx, path, srcDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkg, err := bctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !pkg.Goroot {
+		pkg.ImportPath = importPath
+	}
+
+	return pkg, m, nil
+}
+
+// resolve returns the directory, import path and module of the package
+// at path, imported by a package in srcDir.
+func (ml *modLoader) resolve(bctx *build.Context, path string, srcDir string) (dir string, importPath string, m *module, err error) {
+	if build.IsLocalImport(path) || filepath.IsAbs(path) {
+		dir = path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(srcDir, path)
+		}
+
+		m, err := ml.moduleOf(dir)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if m == nil || !ml.isMain(m.Path) {
+			if _, ok := hasFilePathPrefix(dir, filepath.Join(bctx.GOROOT, "src")); ok {
+				return dir, "", nil, nil
+			}
+			if ml.work != nil {
+				return "", "", nil, fmt.Errorf("directory %s is outside modules listed in go.work", dir)
+			}
+			return "", "", nil, fmt.Errorf("directory %s is outside the main module", dir)
+		}
+
+		rel, err := filepath.Rel(m.Dir, dir)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		return dir, pathpkg.Join(m.Path, filepath.ToSlash(rel)), m, nil
+	}
+
+	goroot := filepath.Join(bctx.GOROOT, "src")
+
+	// The standard library vendors its dependencies in GOROOT/src/vendor.
+	if _, ok := hasFilePathPrefix(srcDir, goroot); ok {
+		dir = filepath.Join(goroot, "vendor", filepath.FromSlash(path))
+		if isDir(dir) {
+			return dir, "", nil, nil
+		}
+	}
+
+	// Vendored builds do not need the module cache.
+	mods := ml.main
+	if ml.vendor == nil {
+		mods, err = ml.buildList()
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	// Paths without a dot in their first element are standard packages,
+	// unless a module of the build provides them, eg. "module myapp".
+	// Like the go command, paths missing from GOROOT are still looked up
+	// in the modules.
+	if isStandardImportPath(path) && !ml.provides(mods, path) {
+		dir = filepath.Join(goroot, filepath.FromSlash(path))
+		if isDir(dir) {
+			return dir, "", nil, nil
+		}
+	}
+
+	if ml.vendor != nil {
+		return ml.resolveVendored(path)
+	}
+
+	// The module with the longest path providing the package wins.
+	var missing *module
+	for _, m := range mods {
+		if m.Path != path && !strings.HasPrefix(path, m.Path+"/") {
+			continue
+		}
+
+		if !isDir(m.Dir) {
+			missing = m
+			continue
+		}
+
+		dir := filepath.Join(m.Dir, filepath.FromSlash(strings.TrimPrefix(path, m.Path)))
+		if hasGoFiles(dir) {
+			return dir, path, m, nil
+		}
+	}
+
+	if missing != nil {
+		return "", "", nil, fmt.Errorf("package %s: module %s@%s is not in the module cache (%s)", path, missing.Path, missing.Version, missing.Dir)
+	}
+
+	if isStandardImportPath(path) {
+		return "", "", nil, fmt.Errorf("package %s is not in std (%s)", path, filepath.Join(goroot, filepath.FromSlash(path)))
+	}
+
+	return "", "", nil, fmt.Errorf("no required module provides package %s", path)
+}
+
+// provides reports whether the path of a module of the build list mods,
+// or of the vendored packages, is a prefix of the import path.
+func (ml *modLoader) provides(mods []*module, path string) bool {
+	if _, ok := ml.vendor[path]; ok {
+		return true
+	}
+
+	for _, m := range mods {
+		if m.Path == path || strings.HasPrefix(path, m.Path+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildList returns the main modules and the versions of the modules they
+// require, ordered from the longest module path to the shortest.
+//
+// Versions are selected like the go command does: go.mod files of Go 1.17
+// and later list every module providing packages to the build, for older
+// ones minimal version selection visits the requirements of every module.
+// The go.mod files of required modules are read from the module cache,
+// a module version missing from it is assumed to have no requirements.
+func (ml *modLoader) buildList() ([]*module, error) {
+	if ml.mods != nil {
+		return ml.mods, nil
+	}
+
+	selected := make(map[string]string)
+	visited := make(map[modVersion]bool)
+
+	var visit func(reqs []modVersion, prune bool) error
+	visit = func(reqs []modVersion, prune bool) error {
+		for _, r := range reqs {
+			if visited[r] || ml.excluded(r) {
+				continue
+			}
+			visited[r] = true
+
+			if v, ok := selected[r.Path]; !ok || compareVersions(v, r.Version) < 0 {
+				selected[r.Path] = r.Version
+			}
+
+			if prune {
+				continue
+			}
+
+			f, err := ml.modFileOf(r)
+			if err != nil {
+				return err
+			}
+			if f != nil {
+				if err := visit(f.Require, false); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	var mods []*module
+	for _, m := range ml.main {
+		mods = append(mods, m)
+
+		if m.File == nil {
+			continue
+		}
+
+		minor, err := parseGoMinor("go" + m.File.Go)
+		prune := err == nil && minor >= 17
+		if err := visit(m.File.Require, prune); err != nil {
+			return nil, err
+		}
+	}
+
+	for path, version := range selected {
+		if ml.isMain(path) {
+			continue
+		}
+
+		m, err := ml.required(modVersion{path, version})
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, m)
+	}
+
+	sort.Slice(mods, func(i, j int) bool {
+		if len(mods[i].Path) != len(mods[j].Path) {
+			return len(mods[i].Path) > len(mods[j].Path)
+		}
+		return mods[i].Path < mods[j].Path
+	})
+
+	ml.mods = mods
+
+	return mods, nil
+}
+
+// required returns the selected version of a required module,
+// from the directory replacing it or from the module cache.
+func (ml *modLoader) required(mv modVersion) (*module, error) {
+	dir := ml.cacheDir(mv)
+	if r, ok := ml.replacement(mv); ok {
+		if r.Version == "" {
+			dir = r.Path
+		} else {
+			dir = ml.cacheDir(r)
+		}
+	}
+
+	if !isDir(dir) {
+		// Reported if the module has to provide a package.
+		return &module{Path: mv.Path, Version: mv.Version, Dir: dir}, nil
+	}
+
+	m, err := ml.load(dir, mv.Path, mv.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// The module is identified by its requirement, even if it is replaced.
+	if m.Path != mv.Path || m.Version != mv.Version {
+		m = &module{Path: mv.Path, Version: mv.Version, Dir: m.Dir, GoVersion: m.GoVersion, File: m.File}
+	}
+
+	return m, nil
+}
+
+// modFileOf returns the go.mod file of a module version,
+// or nil if the module cache has none.
+func (ml *modLoader) modFileOf(mv modVersion) (*modFile, error) {
+	var files []string
+	if r, ok := ml.replacement(mv); ok && r.Version == "" {
+		files = append(files, filepath.Join(r.Path, "go.mod"))
+	} else {
+		if ok {
+			mv = r
+		}
+		files = append(files,
+			filepath.Join(ml.modCache, "cache", "download", escapeModPath(mv.Path), "@v", escapeModPath(mv.Version)+".mod"),
+			filepath.Join(ml.cacheDir(mv), "go.mod"))
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return parseModFile(file, data)
+	}
+
+	return nil, nil
+}
+
+// replacement returns the replacement of a module version by the workspace
+// or the main modules, if any. Directories replacing modules are absolute.
+func (ml *modLoader) replacement(mv modVersion) (modVersion, bool) {
+	// The replacements of the workspace take precedence.
+	if ml.work != nil {
+		if r, ok := findReplacement(ml.work.Replace, ml.work.Dir, mv); ok {
+			return r, true
+		}
+	}
+
+	for _, m := range ml.main {
+		if m.File == nil {
+			continue
+		}
+
+		if r, ok := findReplacement(m.File.Replace, m.Dir, mv); ok {
+			return r, true
+		}
+	}
+
+	return modVersion{}, false
+}
+
+// findReplacement returns the replacement of a module version among replaces
+// declared in the directory dir.
+func findReplacement(replaces []modReplace, dir string, mv modVersion) (modVersion, bool) {
+	var found *modReplace
+	for i, r := range replaces {
+		// A replacement of a specific version takes precedence.
+		if r.Old.Path == mv.Path && (r.Old.Version == mv.Version || r.Old.Version == "" && found == nil) {
+			found = &replaces[i]
+		}
+	}
+
+	if found == nil {
+		return modVersion{}, false
+	}
+
+	r := found.New
+	if r.Version == "" && !filepath.IsAbs(r.Path) {
+		r.Path = filepath.Join(dir, filepath.FromSlash(r.Path))
+	}
+
+	return r, true
+}
+
+// excluded reports whether a main module excludes the module version.
+func (ml *modLoader) excluded(mv modVersion) bool {
+	for _, m := range ml.main {
+		if m.File == nil {
+			continue
+		}
+
+		for _, x := range m.File.Exclude {
+			if x == mv {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isMain reports whether path is the path of a main module.
+func (ml *modLoader) isMain(path string) bool {
+	for _, m := range ml.main {
+		if m.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheDir returns the directory of a module version in the module cache.
+func (ml *modLoader) cacheDir(mv modVersion) string {
+	return filepath.Join(ml.modCache, filepath.FromSlash(escapeModPath(mv.Path)+"@"+escapeModPath(mv.Version)))
+}
+
+// escapeModPath escapes a module path or version for use in the module cache,
+// replacing every upper-case letter with an exclamation mark followed by
+// its lower-case counterpart.
+func escapeModPath(s string) string {
+	var buf strings.Builder
+
+	for _, r := range s {
+		if 'A' <= r && r <= 'Z' {
+			buf.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		buf.WriteRune(r)
+	}
+
+	return buf.String()
+}
+
+// compareVersions compares two semantic versions, eg. v1.2.3 and v1.10.0-pre,
+// returning -1, 0 or +1.
+func compareVersions(v, w string) int {
+	vcore, vpre := splitVersion(v)
+	wcore, wpre := splitVersion(w)
+
+	for i := 0; i < 3; i++ {
+		if c := compareNumbers(vcore[i], wcore[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A version without prerelease has precedence.
+	switch {
+	case vpre == wpre:
+		return 0
+	case vpre == "":
+		return +1
+	case wpre == "":
+		return -1
+	}
+
+	vids := strings.Split(vpre, ".")
+	wids := strings.Split(wpre, ".")
+	for i := 0; i < len(vids) && i < len(wids); i++ {
+		if c := comparePrerelease(vids[i], wids[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A longer prerelease has precedence if its leading identifiers are equal.
+	switch {
+	case len(vids) < len(wids):
+		return -1
+	case len(vids) > len(wids):
+		return +1
+	}
+
+	return 0
+}
+
+// splitVersion splits a semantic version into its major, minor and patch
+// numbers and its prerelease, dropping build metadata.
+func splitVersion(v string) (core [3]string, pre string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+
+	core = [3]string{"0", "0", "0"}
+	for i, n := range strings.SplitN(v, ".", 3) {
+		core[i] = n
+	}
+
+	return core, pre
+}
+
+// comparePrerelease compares prerelease identifiers:
+// numeric ones numerically and below alphanumeric ones.
+func comparePrerelease(x, y string) int {
+	xnum, ynum := isNumber(x), isNumber(y)
+
+	switch {
+	case xnum && ynum:
+		return compareNumbers(x, y)
+	case xnum:
+		return -1
+	case ynum:
+		return +1
+	case x < y:
+		return -1
+	case x > y:
+		return +1
+	}
+
+	return 0
+}
+
+// compareNumbers compares decimal numbers of any length.
+func compareNumbers(x, y string) int {
+	x = strings.TrimLeft(x, "0")
+	y = strings.TrimLeft(y, "0")
+
+	switch {
+	case len(x) < len(y), len(x) == len(y) && x < y:
+		return -1
+	case len(x) > len(y), x > y:
+		return +1
+	}
+
+	return 0
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || '9' < s[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isStandardImportPath reports whether path is the path of a standard package:
+// its first element has no dot.
+func isStandardImportPath(path string) bool {
+	i := strings.Index(path, "/")
+	if i < 0 {
+		i = len(path)
+	}
+
+	return !strings.Contains(path[:i], ".")
+}
+
+// isDir reports whether dir is a directory.
+func isDir(dir string) bool {
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir()
+}
+
+// hasGoFiles reports whether the directory dir has any Go source files.
+func hasGoFiles(dir string) bool {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	for _, fi := range infos {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".go") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestModLoader returns a module loader of the module cache modCache,
+// ignoring go.work files, that loaded the main module of dir.
+func newTestModLoader(t *testing.T, dir string, modCache string) (*modLoader, *build.Context) {
+	t.Helper()
+
+	bctx := build.Default
+	ml := newModLoader(Context{GOMODCACHE: modCache, GOWORK: "off"}, &bctx)
+	if err := ml.loadMain(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	return ml, &bctx
+}
+
+// resolveTest is an import path to resolve, and the directory, import path
+// and module it resolves to or the error resolving it.
+// The module is empty for packages outside of modules.
+type resolveTest struct {
+	path       string
+	dir        string
+	importPath string
+	module     string
+	err        string
+}
+
+// checkResolve resolves the import paths of tests from the directory dir.
+func checkResolve(t *testing.T, ml *modLoader, bctx *build.Context, dir string, tests []resolveTest) {
+	t.Helper()
+
+	for _, test := range tests {
+		gotDir, importPath, m, err := ml.resolve(bctx, test.path, dir)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("resolve(%q): error %v, want %q", test.path, err, test.err)
+			}
+
+		case err != nil:
+			t.Errorf("resolve(%q): %v", test.path, err)
+
+		case gotDir != test.dir || importPath != test.importPath:
+			t.Errorf("resolve(%q) = %q, %q, want %q, %q", test.path, gotDir, importPath, test.dir, test.importPath)
+
+		case test.module == "" && m != nil, test.module != "" && (m == nil || m.Path != test.module):
+			t.Errorf("resolve(%q): module %+v, want %s", test.path, m, test.module)
+		}
+	}
+}
+
+func TestResolveDotlessModule(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":               "module myapp\n\ngo 1.20\n",
+		"main.go":              "package main\n",
+		"internal/x/x.go":      "package x\n",
+		"fmt/fmt.go":           "package fmt\n",
+		"internal/empty/.keep": "",
+	})
+
+	ml, bctx := newTestModLoader(t, dir, t.TempDir())
+
+	checkResolve(t, ml, bctx, dir, []resolveTest{
+		{path: "myapp", dir: dir, importPath: "myapp", module: "myapp"},
+		{path: "myapp/internal/x", dir: filepath.Join(dir, "internal", "x"), importPath: "myapp/internal/x", module: "myapp"},
+		{path: "fmt", dir: filepath.Join(bctx.GOROOT, "src", "fmt")},
+		{path: "myapp/internal/empty", err: "package myapp/internal/empty is not in std"},
+		{path: "nosuchpkg", err: "package nosuchpkg is not in std"},
+		{path: "example.com/nosuchpkg", err: "no required module provides package example.com/nosuchpkg"},
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	// Ordered from the lowest to the highest precedence.
+	versions := []string{
+		"v0.0.0-20200101000000-abcdefabcdef",
+		"v0.9.1",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.2.0",
+		"v1.10.0",
+		"v2.0.0+incompatible",
+	}
+
+	for i, v := range versions {
+		for j, w := range versions {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = +1
+			}
+
+			if got := compareVersions(v, w); got != want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", v, w, got, want)
+			}
+		}
+	}
+
+	// Build metadata is ignored.
+	if got := compareVersions("v1.0.0+meta", "v1.0.0"); got != 0 {
+		t.Errorf("compareVersions(%q, %q) = %d, want 0", "v1.0.0+meta", "v1.0.0", got)
+	}
+}
+
+func TestBuildList(t *testing.T) {
+	// The go.mod files of the module cache:
+	// a requires b v1.1.0, which requires c v1.0.0.
+	cache := map[string]string{
+		"cache/download/example.com/a/@v/v1.0.0.mod": "module example.com/a\n\nrequire example.com/b v1.1.0\n",
+		"cache/download/example.com/b/@v/v1.0.0.mod": "module example.com/b\n",
+		"cache/download/example.com/b/@v/v1.1.0.mod": "module example.com/b\n\nrequire example.com/c v1.0.0\n",
+		"cache/download/example.com/c/@v/v1.0.0.mod": "module example.com/c\n",
+	}
+
+	tests := []struct {
+		name  string
+		gomod string
+		mods  []string
+	}{
+		{
+			name: "unpruned",
+			gomod: `module example.com/m
+go 1.16
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+)
+`,
+			mods: []string{"example.com/a@v1.0.0", "example.com/b@v1.1.0", "example.com/c@v1.0.0", "example.com/m"},
+		},
+		{
+			name: "pruned",
+			gomod: `module example.com/m
+go 1.17
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+)
+`,
+			mods: []string{"example.com/a@v1.0.0", "example.com/b@v1.0.0", "example.com/m"},
+		},
+		{
+			name: "exclude",
+			gomod: `module example.com/m
+go 1.16
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+)
+exclude example.com/b v1.1.0
+`,
+			mods: []string{"example.com/a@v1.0.0", "example.com/b@v1.0.0", "example.com/m"},
+		},
+		{
+			name: "replace",
+			gomod: `module example.com/m
+go 1.16
+require example.com/a v1.0.0
+replace example.com/b v1.1.0 => example.com/b v1.0.0
+`,
+			mods: []string{"example.com/a@v1.0.0", "example.com/b@v1.1.0", "example.com/m"},
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"go.mod": test.gomod})
+
+		modCache := t.TempDir()
+		writeFiles(t, modCache, cache)
+
+		ml, _ := newTestModLoader(t, dir, modCache)
+
+		mods, err := ml.buildList()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var got []string
+		for _, m := range mods {
+			if m.Version == "" {
+				got = append(got, m.Path)
+			} else {
+				got = append(got, m.Path+"@"+m.Version)
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.mods) {
+			t.Errorf("%s: build list %q, want %q", test.name, got, test.mods)
+		}
+	}
+}
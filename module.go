@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// module is a module providing packages.
+type module struct {
+	// Path is the module path, eg. golang.org/x/sys.
+	Path string
+
+	// Version is the version of the module in the module cache,
+	// or the version the build list selects if the module is replaced
+	// (even by a directory). It is empty for main modules.
+	Version string
+
+	// Dir is the root directory of the module.
+	Dir string
+
+	// GoVersion is the go version declared by the module, if any.
+	GoVersion string
+
+	// File is the go.mod file of the module, if it has one.
+	File *modFile
+}
+
+// modLoader finds the modules of package directories.
+type modLoader struct {
+	// modCache is the module cache directory.
+	modCache string
+
+	// mod selects whether the vendor directory is used (see Context.Mod).
+	mod string
+
+	// gowork is the go.work file of the workspace (see Context.GOWORK),
+	// work the workspace, if any.
+	gowork string
+	work   *workFile
+
+	// main are the main modules of the build.
+	main []*module
+
+	// mods is the build list, once loaded.
+	mods []*module
+
+	// vendor are the modules of the vendored packages by package path,
+	// if the vendor directory of the main module is used.
+	vendor map[string]*module
+
+	// modules are the loaded modules by root directory,
+	// dirs the modules of the directories looked up so far.
+	modules map[string]*module
+	dirs    map[string]*module
+}
+
+func newModLoader(ctx Context, bctx *build.Context) *modLoader {
+	return &modLoader{
+		modCache: modCacheDir(ctx, bctx),
+		mod:      ctx.Mod,
+		gowork:   goworkFile(ctx),
+		modules:  make(map[string]*module),
+		dirs:     make(map[string]*module),
+	}
+}
+
+// modCacheDir returns the module cache directory of the build.
+func modCacheDir(ctx Context, bctx *build.Context) string {
+	if ctx.GOMODCACHE != "" {
+		return ctx.GOMODCACHE
+	}
+
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+
+	if list := filepath.SplitList(bctx.GOPATH); len(list) > 0 && list[0] != "" {
+		return filepath.Join(list[0], "pkg", "mod")
+	}
+
+	return ""
+}
+
+// moduleOf returns the module containing the directory dir,
+// or nil if dir is not in a module.
+//
+// Directories in the module cache belong to the module version
+// named by their path@version directory. Other directories belong to
+// the main module of the closest go.mod file in them or above them.
+func (ml *modLoader) moduleOf(dir string) (*module, error) {
+	dir = filepath.Clean(dir)
+
+	if m, ok := ml.dirs[dir]; ok {
+		return m, nil
+	}
+
+	var m *module
+	var err error
+	if rel, ok := hasFilePathPrefix(dir, ml.modCache); ok {
+		m, err = ml.cachedModuleOf(rel)
+	} else {
+		m, err = ml.mainModuleOf(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ml.dirs[dir] = m
+
+	return m, nil
+}
+
+// cachedModuleOf returns the module version containing the directory
+// at rel in the module cache.
+func (ml *modLoader) cachedModuleOf(rel string) (*module, error) {
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+
+	for i, elem := range elems {
+		at := strings.Index(elem, "@")
+		if at < 0 {
+			continue
+		}
+
+		path, err := unescapeModPath(strings.Join(append(elems[:i:i], elem[:at]), "/"))
+		if err != nil {
+			return nil, fmt.Errorf("module cache directory %s: %v", rel, err)
+		}
+
+		version, err := unescapeModPath(elem[at+1:])
+		if err != nil {
+			return nil, fmt.Errorf("module cache directory %s: %v", rel, err)
+		}
+
+		return ml.load(filepath.Join(ml.modCache, filepath.FromSlash(strings.Join(elems[:i+1], "/"))), path, version)
+	}
+
+	return nil, nil
+}
+
+// mainModuleOf returns the module of the closest go.mod file
+// in or above the directory dir.
+func (ml *modLoader) mainModuleOf(dir string) (*module, error) {
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !fi.IsDir() {
+			return ml.load(dir, "", "")
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// load returns the module rooted at dir.
+// If path is empty, it is the module path declared by the go.mod file.
+func (ml *modLoader) load(dir string, path string, version string) (*module, error) {
+	if m, ok := ml.modules[dir]; ok {
+		return m, nil
+	}
+
+	m := &module{
+		Path:    path,
+		Version: version,
+		Dir:     dir,
+	}
+
+	file := filepath.Join(dir, "go.mod")
+	data, err := ioutil.ReadFile(file)
+	switch {
+	case err == nil:
+		m.File, err = parseModFile(file, data)
+		if err != nil {
+			return nil, err
+		}
+		if m.Path == "" {
+			m.Path = m.File.Module
+		}
+		m.GoVersion = m.File.Go
+
+	case os.IsNotExist(err) && path != "":
+		// Modules predating go.mod files have none in the module cache.
+
+	default:
+		return nil, err
+	}
+
+	ml.modules[dir] = m
+
+	return m, nil
+}
+
+// hasFilePathPrefix reports whether path is the directory prefix or below it,
+// and returns the path relative to prefix.
+func hasFilePathPrefix(path, prefix string) (string, bool) {
+	if prefix == "" {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(prefix, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", false
+	}
+
+	return rel, true
+}
+
+// unescapeModPath decodes a module path or version of the module cache,
+// where every upper-case letter is written as an exclamation mark followed
+// by its lower-case counterpart.
+func unescapeModPath(s string) (string, error) {
+	var buf strings.Builder
+
+	bang := false
+	for _, r := range s {
+		if r == utf8.RuneError {
+			return "", fmt.Errorf("invalid escaped path %q", s)
+		}
+
+		switch {
+		case bang:
+			if r < 'a' || 'z' < r {
+				return "", fmt.Errorf("invalid escaped path %q", s)
+			}
+			buf.WriteRune(r - ('a' - 'A'))
+			bang = false
+
+		case r == '!':
+			bang = true
+
+		case 'A' <= r && r <= 'Z':
+			return "", fmt.Errorf("invalid escaped path %q", s)
+
+		default:
+			buf.WriteRune(r)
+		}
+	}
+
+	if bang {
+		return "", fmt.Errorf("invalid escaped path %q", s)
+	}
+
+	return buf.String(), nil
+}
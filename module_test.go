@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestModuleOf(t *testing.T) {
+	modCache := t.TempDir()
+	writeFiles(t, modCache, map[string]string{
+		"example.com/!foo@v1.0.0/go.mod":     "module example.com/Foo\n\ngo 1.18\n",
+		"example.com/!foo@v1.0.0/sub/sub.go": "package sub\n",
+		"example.com/old@v0.1.0/old.go":      "package old\n",
+		"example.com/!bad!@v1.0.0/bad.go":    "package bad\n",
+		"cache/download/example.com/x.txt":   "",
+	})
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":        "module example.com/main\n\ngo 1.20\n",
+		"sub/sub.go":    "package sub\n",
+		"nested/go.mod": "module example.com/nested\n",
+		"nested/a/a.go": "package a\n",
+	})
+
+	ml, _ := newTestModLoader(t, dir, modCache)
+
+	tests := []struct {
+		dir     string
+		path    string
+		version string
+		modDir  string
+		err     bool
+	}{
+		{
+			dir:     filepath.Join(modCache, "example.com", "!foo@v1.0.0", "sub"),
+			path:    "example.com/Foo",
+			version: "v1.0.0",
+			modDir:  filepath.Join(modCache, "example.com", "!foo@v1.0.0"),
+		},
+		{
+			// Modules predating go.mod files have none.
+			dir:     filepath.Join(modCache, "example.com", "old@v0.1.0"),
+			path:    "example.com/old",
+			version: "v0.1.0",
+			modDir:  filepath.Join(modCache, "example.com", "old@v0.1.0"),
+		},
+		{
+			dir: filepath.Join(modCache, "example.com", "!bad!@v1.0.0"),
+			err: true,
+		},
+		{
+			dir: filepath.Join(modCache, "cache", "download", "example.com"),
+		},
+		{
+			dir:    filepath.Join(dir, "sub"),
+			path:   "example.com/main",
+			modDir: dir,
+		},
+		{
+			dir:    filepath.Join(dir, "nested", "a"),
+			path:   "example.com/nested",
+			modDir: filepath.Join(dir, "nested"),
+		},
+		{
+			dir: t.TempDir(),
+		},
+	}
+
+	for _, test := range tests {
+		m, err := ml.moduleOf(test.dir)
+		switch {
+		case test.err:
+			if err == nil {
+				t.Errorf("moduleOf(%s): missing error", test.dir)
+			}
+
+		case err != nil:
+			t.Errorf("moduleOf(%s): %v", test.dir, err)
+
+		case test.path == "":
+			if m != nil {
+				t.Errorf("moduleOf(%s) = %s@%s, want no module", test.dir, m.Path, m.Version)
+			}
+
+		case m == nil:
+			t.Errorf("moduleOf(%s) = no module, want %s", test.dir, test.path)
+
+		case m.Path != test.path || m.Version != test.version || m.Dir != test.modDir:
+			t.Errorf("moduleOf(%s) = %s@%s in %s, want %s@%s in %s", test.dir, m.Path, m.Version, m.Dir, test.path, test.version, test.modDir)
+		}
+	}
+}
+
+func TestUnescapeModPath(t *testing.T) {
+	tests := []struct {
+		s    string
+		path string
+		err  bool
+	}{
+		{s: "example.com/foo", path: "example.com/foo"},
+		{s: "github.com/!azure/azure-sdk-for-go", path: "github.com/Azure/azure-sdk-for-go"},
+		{s: "github.com/!burnt!sushi/toml", path: "github.com/BurntSushi/toml"},
+		{s: "v1.0.0-!r!c1", path: "v1.0.0-RC1"},
+		{s: "example.com/Foo", err: true},
+		{s: "example.com/!", err: true},
+		{s: "example.com/!!foo", err: true},
+		{s: "example.com/!1", err: true},
+		{s: "example.com/\xff", err: true},
+	}
+
+	for _, test := range tests {
+		path, err := unescapeModPath(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("unescapeModPath(%q) = %q, want an error", test.s, path)
+			}
+			continue
+		}
+
+		if err != nil || path != test.path {
+			t.Errorf("unescapeModPath(%q) = %q, %v, want %q", test.s, path, err, test.path)
+		}
+	}
+
+	// Escaping and unescaping are inverses.
+	for _, path := range []string{"example.com/foo", "github.com/BurntSushi/toml", "v1.2.3-RC.1"} {
+		if got, err := unescapeModPath(escapeModPath(path)); err != nil || got != path {
+			t.Errorf("unescapeModPath(escapeModPath(%q)) = %q, %v", path, got, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Ninja writes a Ninja build file building actions to w.
+// Actions must be ordered so that every action comes after its dependencies.
+//
+// If the last action builds a main package and out is not empty,
+// the build file also links the executable to out.
+func Ninja(w io.Writer, ctx Context, t Toolchain, actions []*Action, out string) error {
+	var steps []Step
+
+	for _, a := range actions {
+		s, err := Plan(ctx, t, *a)
+		if err != nil {
+			return err
+		}
+
+		steps = append(steps, s...)
+	}
+
+	if len(actions) > 0 && out != "" && actions[len(actions)-1].Package.Name == "main" {
+		root := actions[len(actions)-1]
+
+		var exec planExecutor
+		if err := Link(ctx, &exec, t, *root, out); err != nil {
+			return err
+		}
+
+		steps = append(steps, exec.steps...)
+	}
+
+	return writeNinja(w, steps)
+}
+
+// writeNinja writes a Ninja build file with one rule per tool and one edge per step.
+//
+// Ninja requires every file to be produced by a single edge,
+// but some steps update a file produced by an earlier step in place
+// (eg. pack adds objects to the archive written by compile).
+// Such steps produce a stamp file instead, which later steps reading the file depend on.
+func writeNinja(w io.Writer, steps []Step) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# Generated by gb. DO NOT EDIT.\n\n")
+
+	rules := make(map[string]bool)
+	writeRule := func(name string, description string) {
+		if rules[name] {
+			return
+		}
+		rules[name] = true
+
+		fmt.Fprintf(bw, "rule %s\n", name)
+		fmt.Fprintf(bw, "  command = $cmd\n")
+		fmt.Fprintf(bw, "  description = %s\n\n", description)
+	}
+
+	produced := make(map[string]bool)
+	stamps := make(map[string][]string)
+
+	for _, s := range steps {
+		var inputs, implicit, outputs []string
+
+		for _, in := range s.Inputs {
+			if len(stamps[in]) > 0 {
+				implicit = append(implicit, stamps[in][len(stamps[in])-1])
+			}
+			inputs = append(inputs, in)
+		}
+
+		var touch []string
+		for _, out := range s.Outputs {
+			if !produced[out] {
+				produced[out] = true
+				outputs = append(outputs, out)
+
+				continue
+			}
+
+			stamp := fmt.Sprintf("%s.%d.stamp", out, len(stamps[out])+1)
+			stamps[out] = append(stamps[out], stamp)
+			outputs = append(outputs, stamp)
+			touch = append(touch, stamp)
+		}
+
+		rule := ninjaRule(s)
+		if s.File != "" {
+			writeRule(rule, "write $out")
+		} else {
+			writeRule(rule, ninjaEscape(s.Tool)+" $pkg")
+		}
+
+		cmd := s.command()
+		for _, stamp := range touch {
+			cmd += " && touch " + shellQuote(stamp)
+		}
+
+		fmt.Fprintf(bw, "build %s: %s", ninjaPaths(outputs), rule)
+		if len(inputs) > 0 {
+			fmt.Fprintf(bw, " %s", ninjaPaths(inputs))
+		}
+		if len(implicit) > 0 {
+			fmt.Fprintf(bw, " | %s", ninjaPaths(implicit))
+		}
+		fmt.Fprintf(bw, "\n")
+		fmt.Fprintf(bw, "  cmd = %s\n", ninjaEscape(cmd))
+		if s.ImportPath != "" {
+			fmt.Fprintf(bw, "  pkg = %s\n", ninjaEscape(s.ImportPath))
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+
+	return bw.Flush()
+}
+
+// ninjaRule returns the name of the rule running s.
+// Tools are named after their binaries (eg. "g++"),
+// which are not necessarily valid Ninja identifiers.
+func ninjaRule(s Step) string {
+	if s.File != "" {
+		return "write"
+	}
+
+	rule := strings.Map(func(r rune) rune {
+		switch {
+		case r == '+':
+			return 'x'
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, s.Tool)
+
+	// "write" is the rule of the steps writing files.
+	if rule == "" || rule == "write" {
+		rule = "run_" + s.Tool
+	}
+
+	return rule
+}
+
+// ninjaEscape escapes s for use in a Ninja variable value.
+func ninjaEscape(s string) string {
+	return strings.Replace(s, "$", "$$", -1)
+}
+
+// ninjaPaths escapes and joins paths for use in a Ninja build statement.
+func ninjaPaths(paths []string) string {
+	r := strings.NewReplacer("$", "$$", " ", "$ ", ":", "$:")
+
+	escaped := make([]string, 0, len(paths))
+	for _, p := range paths {
+		escaped = append(escaped, r.Replace(p))
+	}
+
+	return strings.Join(escaped, " ")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNinjaRule(t *testing.T) {
+	tests := []struct {
+		step Step
+		rule string
+	}{
+		{Step{File: "$WORK/b001/importcfg"}, "write"},
+		{Step{Tool: "compile"}, "compile"},
+		{Step{Tool: "gcc"}, "gcc"},
+		{Step{Tool: "g++"}, "gxx"},
+		{Step{Tool: "x86_64-linux-gnu-g++-12"}, "x86_64-linux-gnu-gxx-12"},
+		{Step{Tool: "gb.exe"}, "gb.exe"},
+		{Step{Tool: "my gb"}, "my_gb"},
+		{Step{Tool: "write"}, "run_write"},
+	}
+
+	for _, test := range tests {
+		if got := ninjaRule(test.step); got != test.rule {
+			t.Errorf("ninjaRule(%+v) = %s, want %s", test.step, got, test.rule)
+		}
+	}
+}
+
+func TestWriteNinja(t *testing.T) {
+	step := func(tool string, inputs []string, outputs []string, args ...string) Step {
+		return Step{
+			ImportPath: "example.com/p",
+			Dir:        "/src/p",
+			Objdir:     "$WORK/b001/",
+			Tool:       tool,
+			Args:       args,
+			Inputs:     inputs,
+			Outputs:    outputs,
+		}
+	}
+
+	steps := []Step{
+		{File: "$WORK/b001/importcfg", Content: "# import config\n", Outputs: []string{"$WORK/b001/importcfg"}},
+		step("compile",
+			[]string{"$WORK/b001/importcfg", "/src/p/p.go"},
+			[]string{"$WORK/b001/_pkg_.a"},
+			"go", "tool", "compile", "-o", "$WORK/b001/_pkg_.a", "-pack", "./p.go"),
+		step("gcc",
+			[]string{"/src/p/x.c"},
+			[]string{"$WORK/b001/_x001_.o"},
+			"gcc", "-c", "-o", "$WORK/b001/_x001_.o", "./x.c"),
+		step("g++",
+			[]string{"/src/p/y.cc"},
+			[]string{"$WORK/b001/_x002_.o"},
+			"g++", "-c", "-o", "$WORK/b001/_x002_.o", "./y.cc"),
+		step("pack",
+			[]string{"$WORK/b001/_pkg_.a", "$WORK/b001/_x001_.o", "$WORK/b001/_x002_.o"},
+			[]string{"$WORK/b001/_pkg_.a"},
+			"go", "tool", "pack", "r", "$WORK/b001/_pkg_.a", "$WORK/b001/_x001_.o", "$WORK/b001/_x002_.o"),
+		step("link",
+			[]string{"$WORK/b001/_pkg_.a"},
+			[]string{"/out/my p"},
+			"go", "tool", "link", "-o", "/out/my p", "$WORK/b001/_pkg_.a"),
+	}
+
+	var buf bytes.Buffer
+	if err := writeNinja(&buf, steps); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	want := `# Generated by gb. DO NOT EDIT.
+
+rule write
+  command = $cmd
+  description = write $out
+
+build $$WORK/b001/importcfg: write
+  cmd = printf '%b' '# import config\n' > '$$WORK/b001/importcfg'
+
+rule compile
+  command = $cmd
+  description = compile $pkg
+
+build $$WORK/b001/_pkg_.a: compile $$WORK/b001/importcfg /src/p/p.go
+  cmd = cd /src/p && go tool compile -o '$$WORK/b001/_pkg_.a' -pack ./p.go
+  pkg = example.com/p
+
+rule gcc
+  command = $cmd
+  description = gcc $pkg
+
+build $$WORK/b001/_x001_.o: gcc /src/p/x.c
+  cmd = cd /src/p && gcc -c -o '$$WORK/b001/_x001_.o' ./x.c
+  pkg = example.com/p
+
+rule gxx
+  command = $cmd
+  description = g++ $pkg
+
+build $$WORK/b001/_x002_.o: gxx /src/p/y.cc
+  cmd = cd /src/p && g++ -c -o '$$WORK/b001/_x002_.o' ./y.cc
+  pkg = example.com/p
+
+rule pack
+  command = $cmd
+  description = pack $pkg
+
+build $$WORK/b001/_pkg_.a.1.stamp: pack $$WORK/b001/_pkg_.a $$WORK/b001/_x001_.o $$WORK/b001/_x002_.o
+  cmd = cd /src/p && go tool pack r '$$WORK/b001/_pkg_.a' '$$WORK/b001/_x001_.o' '$$WORK/b001/_x002_.o' && touch '$$WORK/b001/_pkg_.a.1.stamp'
+  pkg = example.com/p
+
+rule link
+  command = $cmd
+  description = link $pkg
+
+build /out/my$ p: link $$WORK/b001/_pkg_.a | $$WORK/b001/_pkg_.a.1.stamp
+  cmd = cd /src/p && go tool link -o '/out/my p' '$$WORK/b001/_pkg_.a'
+  pkg = example.com/p
+
+`
+	if got != want {
+		t.Errorf("build file:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Every rule and build statement uses a valid Ninja identifier.
+	ident := regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	for _, line := range strings.Split(got, "\n") {
+		var rule string
+		switch {
+		case strings.HasPrefix(line, "rule "):
+			rule = strings.TrimPrefix(line, "rule ")
+		case strings.HasPrefix(line, "build "):
+			rule = strings.Fields(line[strings.Index(line, ": ")+2:])[0]
+		default:
+			continue
+		}
+		if !ident.MatchString(rule) {
+			t.Errorf("invalid rule name %q in %q", rule, line)
+		}
+	}
+}
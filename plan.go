@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// Step is a single build step.
+//
+// A step either runs a command (Args is set) or writes a file (File is set).
+type Step struct {
+	// ImportPath, Dir and Objdir identify the action the step belongs to.
+	ImportPath string `json:"importPath,omitempty"`
+	Dir        string `json:"dir,omitempty"`
+	Objdir     string `json:"objdir,omitempty"`
+
+	// Tool is the short name of the tool run by the step (eg. "compile").
+	Tool string   `json:"tool,omitempty"`
+	Args []string `json:"args,omitempty"`
+	Env  []string `json:"env,omitempty"`
+
+	// Inputs and Outputs are the files declared to be read and written by the step.
+	Inputs  []string `json:"inputs,omitempty"`
+	Outputs []string `json:"outputs,omitempty"`
+
+	File    string `json:"file,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// action returns an action that runs the step in the same environment it was recorded in.
+func (s Step) action() Action {
+	return Action{
+		Package: Package{
+			Package: &build.Package{
+				ImportPath: s.ImportPath,
+				Dir:        s.Dir,
+			},
+		},
+		Objdir: s.Objdir,
+	}
+}
+
+// command returns the step as a single line shell command.
+func (s Step) command() string {
+	if s.File != "" {
+		// printf %b expands the escapes, so the content fits on a single line.
+		content := strings.Replace(s.Content, `\`, `\\`, -1)
+		content = strings.Replace(content, "\n", `\n`, -1)
+
+		return "printf '%b' " + shellQuote(content) + " > " + shellQuote(s.File)
+	}
+
+	var words []string
+	for _, kv := range s.Env {
+		if i := strings.Index(kv, "="); i >= 0 {
+			words = append(words, kv[:i+1]+shellQuote(kv[i+1:]))
+		}
+	}
+	for _, arg := range s.Args {
+		words = append(words, shellQuote(arg))
+	}
+
+	return "cd " + shellQuote(s.Dir) + " && " + strings.Join(words, " ")
+}
+
+// Plan returns the ordered list of steps building a would execute,
+// without running any of them.
+func Plan(ctx Context, t Toolchain, a Action) ([]Step, error) {
+	var exec planExecutor
+
+	if err := Build(ctx, &exec, t, a); err != nil {
+		return nil, err
+	}
+
+	return exec.steps, nil
+}
+
+// Execute runs a list of steps in order.
+func Execute(exec Executor, steps []Step) error {
+	for _, s := range steps {
+		if s.File != "" {
+			if err := exec.WriteFile(s.File, []byte(s.Content)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := exec.Run(s.action(), StepFiles{Inputs: s.Inputs, Outputs: s.Outputs}, s.Env, s.Args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planExecutor records build steps instead of executing them.
+type planExecutor struct {
+	steps []Step
+}
+
+func (e *planExecutor) Run(a Action, files StepFiles, env []string, cmdargs ...interface{}) error {
+	args := stringList(cmdargs...)
+	if len(args) == 0 {
+		return fmt.Errorf("%s: empty command", a.Package.ImportPath)
+	}
+
+	e.steps = append(e.steps, Step{
+		ImportPath: a.Package.ImportPath,
+		Dir:        a.Package.Dir,
+		Objdir:     a.Objdir,
+		Tool:       toolName(args),
+		Args:       args,
+		Env:        env,
+		Inputs:     files.Inputs,
+		Outputs:    files.Outputs,
+	})
+
+	return nil
+}
+
+func (e *planExecutor) WriteFile(path string, content []byte) error {
+	e.steps = append(e.steps, Step{
+		File:    path,
+		Content: string(content),
+		Outputs: []string{path},
+	})
+
+	return nil
+}
+
+// toolName returns the short name of the tool invoked by a command line:
+// the name of the go tool for "go tool <name>" and the base name of the binary otherwise.
+func toolName(args []string) string {
+	if len(args) >= 3 && args[1] == "tool" {
+		return args[2]
+	}
+
+	return filepath.Base(args[0])
+}
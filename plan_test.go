@@ -0,0 +1,210 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testContext returns the context of a build with the go command of the
+// default GOROOT, using an empty module cache and no go.work file.
+func testContext(t *testing.T) Context {
+	t.Helper()
+
+	ctx := Context{
+		GOROOT: build.Default.GOROOT,
+		GOOS:   build.Default.GOOS,
+		GOARCH: build.Default.GOARCH,
+		GoTool: filepath.Join(build.Default.GOROOT, "bin", "go"),
+
+		GOMODCACHE: t.TempDir(),
+		GOWORK:     "off",
+
+		CgoEnabled: build.Default.CgoEnabled,
+	}
+	if _, err := os.Stat(ctx.GoTool); err != nil {
+		t.Skip(err)
+	}
+
+	return ctx
+}
+
+// writeFiles writes files, keyed by their slash-separated path, below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// loadTestActions writes the files of a module below a temporary directory
+// and returns the actions building the package path, as loaded from it.
+func loadTestActions(t *testing.T, ctx Context, files map[string]string, path string) (string, []*Action) {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+
+	actions, err := loadActions(ctx, path, dir, "$WORK")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, actions
+}
+
+// findAction returns the action building the package path.
+func findAction(t *testing.T, actions []*Action, path string) *Action {
+	t.Helper()
+
+	for _, a := range actions {
+		if a.Package.ImportPath == path {
+			return a
+		}
+	}
+
+	t.Fatalf("no action builds %s", path)
+	return nil
+}
+
+// stepName names a step by the tool it runs or the file it writes.
+func stepName(s Step) string {
+	if s.File != "" {
+		return "write " + s.File
+	}
+
+	return s.Tool
+}
+
+func TestPlan(t *testing.T) {
+	ctx := testContext(t)
+	dir, actions := loadTestActions(t, ctx, map[string]string{
+		"go.mod":  "module example.com/p\n\ngo 1.20\n",
+		"a/a.go":  "package a\n\nfunc F() int\n",
+		"a/a.s":   "TEXT ·F(SB),0,$0-8\n\tRET\n",
+		"main.go": "package main\n\nimport \"example.com/p/a\"\n\nfunc main() { println(a.F()) }\n",
+	}, ".")
+
+	t.Run("asm", func(t *testing.T) {
+		a := findAction(t, actions, "example.com/p/a")
+		obj := a.Objdir
+		src := filepath.Join(dir, "a")
+
+		steps, err := Plan(ctx, gcToolchain{}, *a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []Step{
+			{File: obj + "go_asm.h", Outputs: []string{obj + "go_asm.h"}},
+			{Tool: "asm", Inputs: []string{obj + "go_asm.h", filepath.Join(src, "a.s")}, Outputs: []string{obj + "symabis"}},
+			{File: obj + "importcfg", Outputs: []string{obj + "importcfg"}},
+			{Tool: "compile", Inputs: []string{obj + "symabis", obj + "importcfg", filepath.Join(src, "a.go")}, Outputs: []string{obj + "_pkg_.a", obj + "go_asm.h"}},
+			{Tool: "asm", Inputs: []string{obj + "go_asm.h", filepath.Join(src, "a.s")}, Outputs: []string{obj + "a.o"}},
+			{Tool: "pack", Inputs: []string{obj + "_pkg_.a", obj + "a.o"}, Outputs: []string{obj + "_pkg_.a"}},
+		}
+		checkSteps(t, steps, want)
+
+		for _, s := range steps {
+			if s.File == "" && (s.ImportPath != "example.com/p/a" || s.Dir != src || s.Objdir != obj) {
+				t.Errorf("%s step of %s in %s (objdir %s)", s.Tool, s.ImportPath, s.Dir, s.Objdir)
+			}
+		}
+	})
+
+	t.Run("main", func(t *testing.T) {
+		a := actions[len(actions)-1]
+		obj := a.Objdir
+
+		steps, err := Plan(ctx, gcToolchain{}, *a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inputs := append([]string{obj + "importcfg"}, a.depArchives(false)...)
+		want := []Step{
+			{File: obj + "importcfg", Outputs: []string{obj + "importcfg"}},
+			{Tool: "compile", Inputs: append(inputs, filepath.Join(dir, "main.go")), Outputs: []string{obj + "_pkg_.a"}},
+		}
+		checkSteps(t, steps, want)
+	})
+}
+
+// checkSteps compares the names, inputs and outputs of steps with want's.
+func checkSteps(t *testing.T, steps []Step, want []Step) {
+	t.Helper()
+
+	if len(steps) != len(want) {
+		var names []string
+		for _, s := range steps {
+			names = append(names, stepName(s))
+		}
+		t.Fatalf("%d steps %q, want %d", len(steps), names, len(want))
+	}
+
+	for i, s := range steps {
+		w := want[i]
+		if stepName(s) != stepName(w) {
+			t.Errorf("step %d: %s, want %s", i, stepName(s), stepName(w))
+			continue
+		}
+		if !reflect.DeepEqual(s.Inputs, w.Inputs) {
+			t.Errorf("step %d (%s): inputs %q, want %q", i, stepName(s), s.Inputs, w.Inputs)
+		}
+		if !reflect.DeepEqual(s.Outputs, w.Outputs) {
+			t.Errorf("step %d (%s): outputs %q, want %q", i, stepName(s), s.Outputs, w.Outputs)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	steps := []Step{
+		{File: "$WORK/b001/importcfg", Content: "# import config\n", Outputs: []string{"$WORK/b001/importcfg"}},
+		{
+			ImportPath: "example.com/p",
+			Dir:        "/src/p",
+			Objdir:     "$WORK/b001/",
+			Tool:       "compile",
+			Args:       []string{"/go/bin/go", "tool", "compile", "-o", "$WORK/b001/_pkg_.a", "p.go"},
+			Env:        []string{"GOARCH=amd64"},
+			Inputs:     []string{"$WORK/b001/importcfg", "/src/p/p.go"},
+			Outputs:    []string{"$WORK/b001/_pkg_.a"},
+		},
+	}
+
+	var exec planExecutor
+	if err := Execute(&exec, steps); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(exec.steps, steps) {
+		t.Errorf("executed steps\n%+v\nwant\n%+v", exec.steps, steps)
+	}
+}
+
+func TestToolName(t *testing.T) {
+	tests := []struct {
+		args []string
+		tool string
+	}{
+		{[]string{"/go/bin/go", "tool", "compile", "-o", "a.o"}, "compile"},
+		{[]string{"/usr/bin/gcc", "-c", "x.c"}, "gcc"},
+		{[]string{"g++"}, "g++"},
+	}
+
+	for _, test := range tests {
+		if got := toolName(test.args); got != test.tool {
+			t.Errorf("toolName(%q) = %s, want %s", test.args, got, test.tool)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// contextExecutor is implemented by executors that can interrupt running steps.
+type contextExecutor interface {
+	Executor
+
+	// WithContext returns an executor interrupting its steps when ctx is done.
+	WithContext(ctx context.Context) Executor
+}
+
+// BuildParallel builds actions using up to n workers.
+// If n is less than 1, runtime.GOMAXPROCS(0) workers are used.
+//
+// Every dependency of an action must be in actions:
+// an action is built once all of its dependencies are built.
+//
+// The first failure stops the build: no more actions are started,
+// and running steps are interrupted if the executor supports it.
+func BuildParallel(ctx Context, exec Executor, t Toolchain, actions []*Action, n int) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	stdctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if ce, ok := exec.(contextExecutor); ok {
+		exec = ce.WithContext(stdctx)
+	}
+
+	var (
+		mu         sync.Mutex
+		firstErr   error
+		left       = len(actions)
+		pending    = make(map[*Action]int)
+		dependents = make(map[*Action][]*Action)
+	)
+
+	ready := make(chan *Action, len(actions))
+	for _, a := range actions {
+		pending[a] = len(a.Deps)
+		for _, dep := range a.Deps {
+			dependents[dep] = append(dependents[dep], a)
+		}
+
+		if len(a.Deps) == 0 {
+			ready <- a
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				var a *Action
+				select {
+				case <-stdctx.Done():
+					return
+				case a = <-ready:
+				}
+
+				// Both cases may be ready at once: never start after a failure.
+				if stdctx.Err() != nil {
+					return
+				}
+
+				err := Build(ctx, exec, t, *a)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+					mu.Unlock()
+
+					return
+				}
+
+				left--
+				if left == 0 {
+					cancel()
+				}
+
+				for _, d := range dependents[a] {
+					pending[d]--
+					if pending[d] == 0 {
+						ready <- d
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
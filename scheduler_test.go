@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptToolchain compiles each package by running its shell script.
+type scriptToolchain struct {
+	gcToolchain
+
+	scripts map[string]string
+}
+
+func (t scriptToolchain) Gc(ctx Context, exec Executor, a Action, archive string, importcfg string, symabis string, asmhdr bool, gofiles []string) (string, error) {
+	return archive, exec.Run(a, StepFiles{}, nil, "sh", "-c", t.scripts[a.Package.ImportPath])
+}
+
+// scriptActions returns actions building the packages of scripts in dir,
+// every package importing the packages listed for it in deps.
+func scriptActions(dir string, paths []string, deps map[string][]string) []*Action {
+	var actions []*Action
+
+	byPath := make(map[string]*Action)
+	for _, path := range paths {
+		a := &Action{
+			Package: Package{Package: &build.Package{ImportPath: path, Dir: dir}},
+		}
+		for _, dep := range deps[path] {
+			a.Deps = append(a.Deps, byPath[dep])
+		}
+
+		byPath[path] = a
+		actions = append(actions, a)
+	}
+
+	return actions
+}
+
+func TestBuildParallel(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+
+	// Every package checks that its dependencies are built before it.
+	tc := scriptToolchain{scripts: map[string]string{
+		"a": "touch a",
+		"b": "touch b",
+		"c": "test -f a && test -f b && touch c",
+		"d": "test -f c && touch d",
+	}}
+	actions := scriptActions(dir, []string{"a", "b", "c", "d"}, map[string][]string{
+		"c": {"a", "b"},
+		"d": {"c"},
+	})
+
+	if err := BuildParallel(Context{}, newLocalExecutor(""), tc, actions, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "d")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildParallelFailure(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+
+	tc := scriptToolchain{scripts: map[string]string{
+		"a": "echo broken; exit 1",
+		"b": "touch b",
+	}}
+	actions := scriptActions(dir, []string{"a", "b"}, map[string][]string{
+		"b": {"a"},
+	})
+
+	err := BuildParallel(Context{}, newLocalExecutor(""), tc, actions, 2)
+
+	var rerr *runError
+	if !errors.As(err, &rerr) || rerr.ImportPath != "a" || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("error %v, want the failure of a", err)
+	}
+
+	// Packages depending on a failed package are not built.
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Errorf("b was built after a failed: %v", err)
+	}
+}
+
+func TestBuildParallelCancel(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+
+	// a fails while b is running, and c is ready once b is built.
+	tc := scriptToolchain{scripts: map[string]string{
+		"a": "sleep 0.1; exit 1",
+		"b": "exec sleep 60",
+		"c": "touch c",
+	}}
+	actions := scriptActions(dir, []string{"a", "b", "c"}, map[string][]string{
+		"c": {"b"},
+	})
+
+	for name, exec := range map[string]Executor{
+		"local": newLocalExecutor(""),
+		"trace": newShellExecutor("", newLocalExecutor("")),
+	} {
+		start := time.Now()
+		err := BuildParallel(Context{}, exec, tc, actions, 2)
+		elapsed := time.Since(start)
+
+		var rerr *runError
+		if !errors.As(err, &rerr) || rerr.ImportPath != "a" {
+			t.Errorf("%s: error %v, want the failure of a", name, err)
+		}
+
+		// The failure interrupts b rather than waiting for it.
+		if elapsed > 30*time.Second {
+			t.Errorf("%s: build took %v, running steps were not interrupted", name, elapsed)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "c")); !os.IsNotExist(err) {
+			t.Errorf("%s: c was built after a failed: %v", name, err)
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
 type Toolchain interface {
 	// Gc runs the compiler in a specific directory on a set of files
 	// and returns the name of the generated output file.
 	Gc(ctx Context, exec Executor, a Action, archive string, importcfg string, symabis string, asmhdr bool, gofiles []string) (ofile string, err error)
 
-	// Cc runs the toolchain's C compiler in a directory on a C file
-	// to produce an output file.
+	// Cc runs the toolchain's C compiler in a directory on a C, C++,
+	// Objective-C or Fortran file to produce an output file.
 	Cc(ctx Context, exec Executor, a Action, ofile string, cfile string) error
 
 	// Asm runs the assembler in a specific directory on specific files
@@ -25,3 +32,56 @@ type Toolchain interface {
 	// Ld runs the linker to create an executable starting at mainpkg.
 	Ld(ctx Context, exec Executor, a Action, out string, importcfg string, mainpkg string) error
 }
+
+var (
+	toolchainsMu sync.Mutex
+	toolchains   = make(map[string]func(ctx Context) (Toolchain, error))
+)
+
+// RegisterToolchain makes a toolchain available under name.
+// newToolchain returns the toolchain configured by a Context.
+//
+// RegisterToolchain panics if a toolchain is already registered under name.
+func RegisterToolchain(name string, newToolchain func(ctx Context) (Toolchain, error)) {
+	toolchainsMu.Lock()
+	defer toolchainsMu.Unlock()
+
+	if _, ok := toolchains[name]; ok {
+		panic("toolchain " + name + " registered twice")
+	}
+
+	toolchains[name] = newToolchain
+}
+
+// Toolchains returns the names of the registered toolchains in sorted order.
+func Toolchains() []string {
+	toolchainsMu.Lock()
+	defer toolchainsMu.Unlock()
+
+	names := make([]string, 0, len(toolchains))
+	for name := range toolchains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewToolchain returns the toolchain registered under ctx.Compiler,
+// or the gc toolchain if ctx.Compiler is empty.
+func NewToolchain(ctx Context) (Toolchain, error) {
+	name := ctx.Compiler
+	if name == "" {
+		name = "gc"
+	}
+
+	toolchainsMu.Lock()
+	newToolchain, ok := toolchains[name]
+	toolchainsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown compiler %q (available: %s)", name, strings.Join(Toolchains(), ", "))
+	}
+
+	return newToolchain(ctx)
+}
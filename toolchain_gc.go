@@ -5,9 +5,7 @@
 package main
 
 import (
-	"fmt"
 	"path/filepath"
-	"runtime"
 	"strings"
 )
 
@@ -16,6 +14,12 @@ const trimPathGoRootFinal = "go"
 
 type gcToolchain struct{}
 
+func init() {
+	RegisterToolchain("gc", func(ctx Context) (Toolchain, error) {
+		return gcToolchain{}, nil
+	})
+}
+
 func (g gcToolchain) Gc(ctx Context, exec Executor, a Action, archive string, importcfg string, symabis string, asmhdr bool, gofiles []string) (ofile string, err error) {
 	p := a.Package
 
@@ -28,34 +32,37 @@ func (g gcToolchain) Gc(ctx Context, exec Executor, a Action, archive string, im
 		ofile = objdir + out
 	}
 
-	pkgpath := pkgPath(a)
-	gcargs := []string{"-p", pkgpath}
-
-	// If we're giving the compiler the entire package (no C etc files), tell it that,
-	// so that it can give good error messages about forward declarations.
-	// Exceptions: a few standard packages have forward declarations for
-	// pieces supplied behind-the-scenes by package runtime.
-	extFiles := len(p.CgoFiles) + len(p.CFiles) + len(p.CXXFiles) + len(p.MFiles) + len(p.FFiles) + len(p.SFiles) + len(p.SysoFiles) + len(p.SwigFiles) + len(p.SwigCXXFiles)
-	if extFiles == 0 {
-		gcargs = append(gcargs, "-complete")
+	v, err := ctx.goVersion()
+	if err != nil {
+		return "", err
 	}
+	gcargs := gcFlags(v, a)
 
 	if ctx.GOOS == "plan9" || ctx.GOARCH == "wasm" {
 		gcargs = append(gcargs, "-dwarf=false")
 	}
 
-	if runtimeVersion := runtime.Version(); strings.HasPrefix(runtimeVersion, "go1") {
-		gcargs = append(gcargs, "-goversion", runtimeVersion)
-	}
-
 	if symabis != "" {
 		gcargs = append(gcargs, "-symabis", symabis)
 	}
 
-	args := []interface{}{ctx.GoTool, "tool", "compile", "-o", ofile, "-trimpath", a.trimpath(), gcargs}
+	args := []interface{}{ctx.GoTool, "tool", "compile", "-o", ofile, "-trimpath", a.trimpath(ctx), gcargs}
+
+	files := StepFiles{
+		Outputs: []string{ofile},
+	}
+
+	if symabis != "" {
+		files.Inputs = append(files.Inputs, symabis)
+	}
 
 	if importcfg != "" {
 		args = append(args, "-importcfg", importcfg)
+		files.Inputs = append(files.Inputs, importcfg)
+	}
+	files.Inputs = append(files.Inputs, a.depArchives(false)...)
+	if stamp := cgoLdflagsStamp(exec, a); stamp != "" {
+		files.Inputs = append(files.Inputs, stamp)
 	}
 
 	if ofile == archive {
@@ -64,36 +71,63 @@ func (g gcToolchain) Gc(ctx Context, exec Executor, a Action, archive string, im
 
 	if asmhdr {
 		args = append(args, "-asmhdr", objdir+"go_asm.h")
+		files.Outputs = append(files.Outputs, objdir+"go_asm.h")
 	}
 
 	for _, f := range gofiles {
 		args = append(args, mkAbs(p.Dir, f))
+		files.Inputs = append(files.Inputs, mkAbs(p.Dir, f))
 	}
 
-	err = exec.Run(a, nil, args...)
+	err = exec.Run(a, files, ctx.goarchEnv(), args...)
 
 	return ofile, err
 }
 
 func (g gcToolchain) Cc(ctx Context, exec Executor, a Action, ofile string, cfile string) error {
-	return fmt.Errorf("%s: C source files not supported without cgo", mkAbs(a.Package.Dir, cfile))
+	cc := newCToolchain(ctx)
+
+	flags, err := cc.fileFlags(a.Package, cfile)
+	if err != nil {
+		return err
+	}
+
+	return cc.compile(exec, a, ofile, flags, cfile)
 }
 
-func asmArgs(ctx Context, a Action) []interface{} {
+func asmArgs(ctx Context, a Action) ([]interface{}, error) {
 	// Add -I pkg/GOOS_GOARCH so #include "textflag.h" works in .s files.
 	inc := filepath.Join(ctx.GOROOT, "pkg", "include")
 
-	pkgpath := pkgPath(a)
+	v, err := ctx.goVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{ctx.GoTool, "tool", "asm", asmFlags(v, a), "-trimpath", a.trimpath(ctx), "-I", a.Objdir, "-I", inc, "-D", "GOOS_" + ctx.GOOS, "-D", "GOARCH_" + ctx.GOARCH}
+
+	args = append(args, ctx.goarchDefines())
 
-	args := []interface{}{ctx.GoTool, "tool", "asm", "-p", pkgpath, "-trimpath", a.trimpath(), "-I", a.Objdir, "-I", inc, "-D", "GOOS_" + ctx.GOOS, "-D", "GOARCH_" + ctx.GOARCH}
+	return args, nil
+}
 
-	// GOMIPS
+// asmInputs returns the files assembling any of the package's
+// assembly files may read: the package's headers and go_asm.h.
+func asmInputs(a Action) []string {
+	var inputs []string
+
+	for _, hfile := range a.Package.HFiles {
+		inputs = append(inputs, mkAbs(a.Package.Dir, hfile))
+	}
 
-	return args
+	return append(inputs, a.Objdir+"go_asm.h")
 }
 
 func (g gcToolchain) Asm(ctx Context, exec Executor, a Action, sfiles []string) ([]string, error) {
-	args := asmArgs(ctx, a)
+	args, err := asmArgs(ctx, a)
+	if err != nil {
+		return nil, err
+	}
 
 	var ofiles []string
 
@@ -101,7 +135,11 @@ func (g gcToolchain) Asm(ctx Context, exec Executor, a Action, sfiles []string)
 		ofile := a.Objdir + sfile[:len(sfile)-len(".s")] + ".o"
 		ofiles = append(ofiles, ofile)
 		args1 := append(args, "-o", ofile, sfile)
-		if err := exec.Run(a, nil, args1...); err != nil {
+		files := StepFiles{
+			Inputs:  append(asmInputs(a), mkAbs(a.Package.Dir, sfile)),
+			Outputs: []string{ofile},
+		}
+		if err := exec.Run(a, files, ctx.goarchEnv(), args1...); err != nil {
 			return nil, err
 		}
 	}
@@ -111,10 +149,18 @@ func (g gcToolchain) Asm(ctx Context, exec Executor, a Action, sfiles []string)
 
 func (g gcToolchain) Symabis(ctx Context, exec Executor, a Action, sfiles []string) (string, error) {
 	mkSymabis := func(p Package, sfiles []string, path string) error {
-		args := asmArgs(ctx, a)
+		args, err := asmArgs(ctx, a)
+		if err != nil {
+			return err
+		}
 		args = append(args, "-gensymabis", "-o", path)
+		files := StepFiles{
+			Inputs:  asmInputs(a),
+			Outputs: []string{path},
+		}
 		for _, sfile := range sfiles {
 			args = append(args, mkAbs(p.Dir, sfile))
+			files.Inputs = append(files.Inputs, mkAbs(p.Dir, sfile))
 		}
 
 		// Supply an empty go_asm.h as if the compiler had been run.
@@ -124,7 +170,7 @@ func (g gcToolchain) Symabis(ctx Context, exec Executor, a Action, sfiles []stri
 			return err
 		}
 
-		return exec.Run(a, nil, args...)
+		return exec.Run(a, files, ctx.goarchEnv(), args...)
 	}
 
 	var symabis string // Only set if we actually create the file
@@ -144,21 +190,67 @@ func (g gcToolchain) Pack(ctx Context, exec Executor, a Action, afile string, of
 
 	args := []interface{}{ctx.GoTool, "tool", "pack", "r", absAfile}
 
+	// The archive is updated in place.
+	files := StepFiles{
+		Inputs:  []string{absAfile},
+		Outputs: []string{absAfile},
+	}
+
 	for _, f := range ofiles {
 		args = append(args, mkAbs(a.Objdir, f))
+		files.Inputs = append(files.Inputs, mkAbs(a.Objdir, f))
 	}
 
-	return exec.Run(a, nil, args...)
+	return exec.Run(a, files, ctx.goarchEnv(), args...)
 }
 
 func (g gcToolchain) Ld(ctx Context, exec Executor, a Action, out string, importcfg string, mainpkg string) error {
 	var ldflags []string
 
-	ldflags = append(ldflags, a.Package.CgoLDFLAGS...) // TODO: only if cgo?
+	// The flags of #cgo LDFLAGS directives reach the linker through
+	// the //go:cgo_ldflag directives recorded in the package archives.
+	// Only tell the linker which C compiler links externally:
+	// the C++ compiler if any package has C++ code.
+	cc := newCToolchain(ctx)
+	compiler := cc.CC
+	for _, d := range append([]*Action{&a}, a.deps(true)...) {
+		if len(d.Package.CXXFiles) > 0 || len(d.Package.SwigCXXFiles) > 0 {
+			compiler = cc.CXX
+		}
+	}
+	ldflags = append(ldflags, "-extld="+compiler[0])
+	if len(compiler) > 1 {
+		ldflags = append(ldflags, "-extldflags="+strings.Join(compiler[1:], " "))
+	}
+
+	v, err := ctx.goVersion()
+	if err != nil {
+		return err
+	}
+
+	link := []string{ctx.GoTool, "tool", "link"}
+
+	env := ctx.goarchEnv()
+	if v.has(featureGorootFinal) {
+		if goroot, ok := ctx.trimmedGoroot(trimPathGoRootFinal); ok {
+			env = append(env, "GOROOT_FINAL="+goroot)
+		}
+	} else if goroot, ok := ctx.trimmedGoroot(""); ok {
+		// The linker records $GOROOT, which is cleared when paths are trimmed.
+		// go tool resets $GOROOT, so run the linker directly.
+		env = append(env, "GOROOT="+goroot)
+
+		dir, err := ctx.goToolDir()
+		if err != nil {
+			return err
+		}
+		link = []string{filepath.Join(dir, "link")}
+	}
 
-	env := []string{}
-	if true { // TODO: TRIMPATH
-		env = append(env, "GOROOT_FINAL="+trimPathGoRootFinal)
+	files := StepFiles{
+		Inputs:  append([]string{importcfg, mainpkg}, a.depArchives(true)...),
+		Outputs: []string{out},
 	}
-	return exec.Run(a, env, ctx.GoTool, "tool", "link", "-o", out, "-importcfg", importcfg, ldflags, mainpkg)
+
+	return exec.Run(a, files, env, link, "-o", out, "-importcfg", importcfg, ldflags, mainpkg)
 }
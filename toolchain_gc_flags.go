@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// gcFeature is a flag of the gc tools that only some Go releases
+// understand or need.
+type gcFeature int
+
+const (
+	// featureCompilingRuntime: the compiler has to be told (-+) it is
+	// building a runtime package. Later releases derive that from -p and -std.
+	featureCompilingRuntime gcFeature = iota
+
+	// featureAsmCompilingRuntime: the assembler has to be told
+	// (-compiling-runtime) it is building a runtime package.
+	featureAsmCompilingRuntime
+
+	// featureAsmStd: the assembler has a -std flag for standard packages.
+	featureAsmStd
+
+	// featureLang: packages outside modules are compiled with -lang
+	// of the language version of the toolchain itself.
+	featureLang
+
+	// featureCgoTrimpath: cgo has a -trimpath flag rewriting the paths
+	// of its line directives.
+	featureCgoTrimpath
+
+	// featureGorootFinal: the linker records GOROOT_FINAL as the GOROOT
+	// of the executable. Go 1.22 dropped it (https://go.dev/issue/62047).
+	featureGorootFinal
+)
+
+// gcFeatures lists the Go releases supporting each feature,
+// from Go 1.since up to, but not including, Go 1.until.
+// A zero until means the feature is still supported.
+var gcFeatures = []struct {
+	feature      gcFeature
+	since, until int
+}{
+	{featureCompilingRuntime, 0, 21},
+	{featureAsmCompilingRuntime, 16, 21},
+	{featureAsmStd, 21, 0},
+	{featureLang, 21, 0},
+	{featureCgoTrimpath, 16, 0},
+	{featureGorootFinal, 0, 22},
+}
+
+// has reports whether the gc toolchain of release v supports f.
+func (v goVersion) has(f gcFeature) bool {
+	for _, r := range gcFeatures {
+		if r.feature == f {
+			return v.atLeast(r.since) && (r.until == 0 || !v.atLeast(r.until))
+		}
+	}
+
+	return false
+}
+
+// gcFlags returns the compiler flags describing the package built by a
+// to the gc compiler of release v.
+func gcFlags(v goVersion, a Action) []string {
+	p := a.Package
+
+	flags := []string{"-p", pkgPath(a)}
+
+	// The language version is the one declared by the module,
+	// unless it is newer than the toolchain.
+	// Modules declaring none, eg. vendored ones, are Go 1.16 modules.
+	goVersion := p.ModuleGoVersion
+	if goVersion == "" && p.ModulePath != "" {
+		goVersion = "1.16"
+	}
+	if minor, err := parseGoMinor("go" + goVersion); err == nil && minor <= v.Minor {
+		flags = append(flags, "-lang=go1."+strconv.Itoa(minor))
+	} else if v.has(featureLang) {
+		flags = append(flags, "-lang=go1."+strconv.Itoa(v.Minor))
+	}
+
+	if p.Goroot {
+		flags = append(flags, "-std")
+	}
+
+	if p.Goroot && v.has(featureCompilingRuntime) && isRuntimePackage(p.ImportPath) {
+		flags = append(flags, "-+")
+	}
+
+	// If we're giving the compiler the entire package (no C etc files), tell it that,
+	// so that it can give good error messages about forward declarations.
+	// Exceptions: a few standard packages have forward declarations for
+	// pieces supplied behind-the-scenes by package runtime.
+	extFiles := len(p.CgoFiles) + len(p.CFiles) + len(p.CXXFiles) + len(p.MFiles) + len(p.FFiles) + len(p.SFiles) + len(p.SysoFiles) + len(p.SwigFiles) + len(p.SwigCXXFiles)
+	if p.Goroot {
+		switch p.ImportPath {
+		case "bytes", "internal/poll", "net", "os":
+			fallthrough
+		case "runtime/metrics", "runtime/pprof", "runtime/trace":
+			fallthrough
+		case "sync", "syscall", "time":
+			extFiles++
+		}
+	}
+	if extFiles == 0 {
+		flags = append(flags, "-complete")
+	}
+
+	if strings.HasPrefix(v.Name, "go1") {
+		flags = append(flags, "-goversion", v.Name)
+	}
+
+	return flags
+}
+
+// asmFlags returns the assembler flags describing the package built by a
+// to the gc assembler of release v.
+func asmFlags(v goVersion, a Action) []string {
+	p := a.Package
+
+	flags := []string{"-p", pkgPath(a)}
+
+	if p.Goroot && v.has(featureAsmStd) {
+		flags = append(flags, "-std")
+	}
+
+	if p.Goroot && v.has(featureAsmCompilingRuntime) && isRuntimePackage(p.ImportPath) {
+		flags = append(flags, "-compiling-runtime")
+	}
+
+	return flags
+}
+
+// isRuntimePackage reports whether the standard package path is part of the
+// runtime, which releases before Go 1.21 had to be told about.
+func isRuntimePackage(path string) bool {
+	switch path {
+	case "runtime", "internal/abi", "internal/bytealg", "internal/cpu":
+		return true
+	}
+
+	return strings.HasPrefix(path, "runtime/internal/")
+}
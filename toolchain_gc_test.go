@@ -0,0 +1,102 @@
+package main
+
+import (
+	"go/build"
+	"testing"
+)
+
+func TestGcToolchainStepFiles(t *testing.T) {
+	ctx := testContext(t)
+
+	indirect := &Action{
+		Package: Package{Package: &build.Package{ImportPath: "example.com/indirect", Dir: "/src/indirect"}},
+		Objdir:  "$WORK/b001/",
+	}
+	dep := &Action{
+		Package: Package{Package: &build.Package{ImportPath: "example.com/dep", Dir: "/src/dep"}},
+		Objdir:  "$WORK/b002/",
+		Deps:    []*Action{indirect},
+	}
+	a := Action{
+		Package: Package{Package: &build.Package{
+			ImportPath: "example.com/p",
+			Name:       "main",
+			Dir:        "/src/p",
+			HFiles:     []string{"p.h"},
+		}},
+		Objdir: "$WORK/b003/",
+		Deps:   []*Action{dep},
+	}
+
+	var g gcToolchain
+	tests := []struct {
+		name string
+		run  func(exec Executor) error
+		want []Step
+	}{
+		{
+			name: "compile",
+			run: func(exec Executor) error {
+				_, err := g.Gc(ctx, exec, a, a.Objdir+"_pkg_.a", a.Objdir+"importcfg", a.Objdir+"symabis", true, []string{"p.go", "q.go"})
+				return err
+			},
+			want: []Step{{
+				Tool:    "compile",
+				Inputs:  []string{"$WORK/b003/symabis", "$WORK/b003/importcfg", "$WORK/b002/_pkg_.a", "/src/p/p.go", "/src/p/q.go"},
+				Outputs: []string{"$WORK/b003/_pkg_.a", "$WORK/b003/go_asm.h"},
+			}},
+		},
+		{
+			name: "asm",
+			run: func(exec Executor) error {
+				_, err := g.Asm(ctx, exec, a, []string{"a.s", "b.s"})
+				return err
+			},
+			want: []Step{
+				{
+					Tool:    "asm",
+					Inputs:  []string{"/src/p/p.h", "$WORK/b003/go_asm.h", "/src/p/a.s"},
+					Outputs: []string{"$WORK/b003/a.o"},
+				},
+				{
+					Tool:    "asm",
+					Inputs:  []string{"/src/p/p.h", "$WORK/b003/go_asm.h", "/src/p/b.s"},
+					Outputs: []string{"$WORK/b003/b.o"},
+				},
+			},
+		},
+		{
+			name: "pack",
+			run: func(exec Executor) error {
+				return g.Pack(ctx, exec, a, "_pkg_.a", []string{"a.o", "b.o"})
+			},
+			want: []Step{{
+				Tool:    "pack",
+				Inputs:  []string{"$WORK/b003/_pkg_.a", "$WORK/b003/a.o", "$WORK/b003/b.o"},
+				Outputs: []string{"$WORK/b003/_pkg_.a"},
+			}},
+		},
+		{
+			name: "link",
+			run: func(exec Executor) error {
+				return g.Ld(ctx, exec, a, "/out/p", a.Objdir+"importcfg.link", a.Objdir+"_pkg_.a")
+			},
+			want: []Step{{
+				Tool:    "link",
+				Inputs:  []string{"$WORK/b003/importcfg.link", "$WORK/b003/_pkg_.a", "$WORK/b002/_pkg_.a", "$WORK/b001/_pkg_.a"},
+				Outputs: []string{"/out/p"},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var exec planExecutor
+			if err := test.run(&exec); err != nil {
+				t.Fatal(err)
+			}
+
+			checkSteps(t, exec.steps, test.want)
+		})
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The Gccgo toolchain.
+//
+// Standard packages are not built: they are provided by the gccgo installation.
+type gccgoToolchain struct{}
+
+func init() {
+	RegisterToolchain("gccgo", func(ctx Context) (Toolchain, error) {
+		return gccgoToolchain{}, nil
+	})
+}
+
+func (gccgoToolchain) compiler() string {
+	return envList("GCCGO", "gccgo")[0]
+}
+
+func (gccgoToolchain) linker() string {
+	return envList("GCCGO", "gccgo")[0]
+}
+
+func (gccgoToolchain) ar() []string {
+	return envList("AR", "ar")
+}
+
+func (tools gccgoToolchain) Gc(ctx Context, exec Executor, a Action, archive string, importcfg string, symabis string, asmhdr bool, gofiles []string) (ofile string, err error) {
+	p := a.Package
+	objdir := a.Objdir
+	out := "_go_.o"
+	ofile = objdir + out
+	gcargs := []string{"-g"}
+	gcargs = append(gcargs, gccArchArgs(ctx)...)
+	gcargs = append(gcargs, newCToolchain(ctx).prefixMapFlags([]string{tools.compiler()}, a)...)
+	if pkgpath := gccgoPkgpath(a); pkgpath != "" {
+		gcargs = append(gcargs, "-fgo-pkgpath="+pkgpath)
+	}
+
+	args := stringList(tools.compiler(), "-c", gcargs, "-o", ofile)
+
+	files := StepFiles{
+		Outputs: []string{ofile},
+	}
+
+	if importcfg != "" {
+		args = append(args, "-fgo-importcfg="+importcfg)
+		files.Inputs = append(files.Inputs, importcfg)
+	}
+	files.Inputs = append(files.Inputs, a.depArchives(false)...)
+
+	for _, f := range gofiles {
+		args = append(args, mkAbs(p.Dir, f))
+		files.Inputs = append(files.Inputs, mkAbs(p.Dir, f))
+	}
+
+	err = exec.Run(a, files, nil, args)
+
+	return ofile, err
+}
+
+func (tools gccgoToolchain) Asm(ctx Context, exec Executor, a Action, sfiles []string) ([]string, error) {
+	p := a.Package
+	var ofiles []string
+	for _, sfile := range sfiles {
+		base := filepath.Base(sfile)
+		ofile := a.Objdir + base[:len(base)-len(".s")] + ".o"
+		ofiles = append(ofiles, ofile)
+		sfile = mkAbs(p.Dir, sfile)
+		defs := []string{"-D", "GOOS_" + ctx.GOOS, "-D", "GOARCH_" + ctx.GOARCH}
+		pkgpath, err := tools.gccgoCleanPkgpath(a)
+		if err != nil {
+			return nil, err
+		}
+		if pkgpath != "" {
+			defs = append(defs, `-D`, `GOPKGPATH=`+pkgpath)
+		}
+		defs = append(defs, gccArchArgs(ctx)...)
+
+		files := StepFiles{
+			Inputs:  append(mkAbsFiles(p.Dir, p.HFiles), sfile),
+			Outputs: []string{ofile},
+		}
+
+		err = exec.Run(a, files, nil, tools.compiler(), "-xassembler-with-cpp", "-I", a.Objdir, "-c", "-o", ofile, defs, sfile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ofiles, nil
+}
+
+func (gccgoToolchain) Symabis(ctx Context, exec Executor, a Action, sfiles []string) (string, error) {
+	return "", nil
+}
+
+func (tools gccgoToolchain) Pack(ctx Context, exec Executor, a Action, afile string, ofiles []string) error {
+	objdir := a.Objdir
+	absOfiles := make([]string, 0, len(ofiles))
+	for _, f := range ofiles {
+		absOfiles = append(absOfiles, mkAbs(objdir, f))
+	}
+	var arArgs []string
+	if ctx.GOOS == "aix" && ctx.GOARCH == "ppc64" {
+		// AIX puts both 32-bit and 64-bit objects in the same archive.
+		// Tell the AIX "ar" command to only care about 64-bit objects.
+		arArgs = []string{"-X64"}
+	}
+	absAfile := mkAbs(objdir, afile)
+
+	files := StepFiles{
+		Inputs:  absOfiles,
+		Outputs: []string{absAfile},
+	}
+
+	// Use the D modifier for deterministic archives if ar supports it.
+	op := "rc"
+	if tools.arSupportsD(arArgs) {
+		op = "rcD"
+	}
+
+	return exec.Run(a, files, nil, tools.ar(), arArgs, op, absAfile, absOfiles)
+}
+
+var (
+	arDCacheMu sync.Mutex
+	arDCache   = make(map[string]bool)
+)
+
+// arSupportsD reports whether ar supports the D modifier,
+// by archiving an empty file with it.
+func (tools gccgoToolchain) arSupportsD(arArgs []string) bool {
+	ar := stringList(tools.ar(), arArgs)
+	key := strings.Join(ar, " ")
+
+	arDCacheMu.Lock()
+	defer arDCacheMu.Unlock()
+
+	if b, ok := arDCache[key]; ok {
+		return b
+	}
+
+	supported := false
+	if dir, err := ioutil.TempDir("", "gb-ar"); err == nil {
+		defer os.RemoveAll(dir)
+
+		member := filepath.Join(dir, "x.o")
+		if err := ioutil.WriteFile(member, nil, 0666); err == nil {
+			cmd := exec.Command(ar[0], stringList(ar[1:], "rcD", filepath.Join(dir, "x.a"), member)...)
+			supported = cmd.Run() == nil
+		}
+	}
+
+	arDCache[key] = supported
+
+	return supported
+}
+
+func (tools gccgoToolchain) Ld(ctx Context, exec Executor, a Action, out string, importcfg string, mainpkg string) error {
+	// gccgo needs explicit linking with all package dependencies,
+	// and all LDFLAGS from cgo dependencies.
+	afiles := []string{}
+	ldflags := gccArchArgs(ctx)
+	cgoldflags := []string{}
+	usesCgo := false
+	cxx := false
+	objc := false
+	fortran := false
+
+	newID := 0
+	for _, d := range append([]*Action{&a}, a.deps(true)...) {
+		p := d.Package
+
+		archive := d.archive()
+		if d == &a {
+			archive = mainpkg
+		}
+		if len(p.CgoFiles) > 0 {
+			usesCgo = true
+
+			newID++
+			newArchive := a.Objdir + fmt.Sprintf("_pkg%d_.a", newID)
+			flags, err := tools.readAndRemoveCgoFlags(ctx, exec, a, *d, archive, newArchive)
+			if err != nil {
+				return err
+			}
+			cgoldflags = append(cgoldflags, flags...)
+			archive = newArchive
+		}
+		afiles = append(afiles, archive)
+
+		if len(p.CXXFiles) > 0 || len(p.SwigCXXFiles) > 0 {
+			cxx = true
+		}
+		if len(p.MFiles) > 0 {
+			objc = true
+		}
+		if len(p.FFiles) > 0 {
+			fortran = true
+		}
+	}
+
+	wholeArchive := []string{"-Wl,--whole-archive"}
+	noWholeArchive := []string{"-Wl,--no-whole-archive"}
+	if ctx.GOOS == "aix" {
+		wholeArchive = nil
+		noWholeArchive = nil
+	}
+	ldflags = append(ldflags, wholeArchive...)
+	ldflags = append(ldflags, afiles...)
+	ldflags = append(ldflags, noWholeArchive...)
+
+	ldflags = append(ldflags, cgoldflags...)
+	ldflags = append(ldflags, envList("CGO_LDFLAGS", "")...)
+	if ctx.GOOS != "aix" {
+		ldflags = stringList("-Wl,-(", ldflags, "-Wl,-)")
+	}
+
+	if usesCgo && ctx.GOOS == "linux" {
+		ldflags = append(ldflags, "-Wl,-E")
+	}
+
+	if cxx {
+		ldflags = append(ldflags, "-lstdc++")
+	}
+	if objc {
+		ldflags = append(ldflags, "-lobjc")
+	}
+	if fortran {
+		// support gfortran out of the box and let others pass the correct link options
+		// via CGO_LDFLAGS
+		if strings.Contains(strings.Join(newCToolchain(ctx).FC, " "), "gfortran") {
+			ldflags = append(ldflags, "-lgfortran")
+		}
+	}
+
+	files := StepFiles{
+		Inputs:  afiles,
+		Outputs: []string{out},
+	}
+
+	return exec.Run(a, files, nil, tools.linker(), "-o", out, ldflags)
+}
+
+func (tools gccgoToolchain) Cc(ctx Context, exec Executor, a Action, ofile string, cfile string) error {
+	p := a.Package
+	cc := newCToolchain(ctx)
+	inc := filepath.Join(ctx.GOROOT, "pkg", "include")
+
+	flags, err := cc.fileFlags(p, cfile)
+	if err != nil {
+		return err
+	}
+
+	defs := []string{"-D", "GOOS_" + ctx.GOOS, "-D", "GOARCH_" + ctx.GOARCH}
+	pkgpath, err := tools.gccgoCleanPkgpath(a)
+	if err != nil {
+		return err
+	}
+	if pkgpath != "" {
+		defs = append(defs, `-D`, `GOPKGPATH="`+pkgpath+`"`)
+	}
+	if cc.supportsFlag(cc.CC, "-fsplit-stack") {
+		defs = append(defs, "-fsplit-stack")
+	}
+	if cc.supportsFlag(cc.CC, "-gno-record-gcc-switches") {
+		defs = append(defs, "-gno-record-gcc-switches")
+	}
+
+	return cc.compile(exec, a, ofile, stringList("-Wall", "-g", "-I", a.Objdir, "-I", inc, defs, flags), cfile)
+}
+
+// readAndRemoveCgoFlags copies the archive of the cgo package built by d
+// to newArchive without its _cgo_flags member, which is no object file,
+// and returns the _CGO_LDFLAGS recorded in it.
+//
+// The flags are read from the _cgo_flags file cgo wrote to the object
+// directory of d, the one packed into the archive, so the executor
+// must run the build: the flags are not known to recorded builds.
+func (tools gccgoToolchain) readAndRemoveCgoFlags(ctx Context, exec Executor, a Action, d Action, archive string, newArchive string) ([]string, error) {
+	r, ok := executorFileReader(exec)
+	if !ok {
+		return nil, fmt.Errorf("%s: the LDFLAGS of cgo package %s are only known to gccgo once the build runs", a.Package.ImportPath, d.Package.ImportPath)
+	}
+
+	var arArgs []string
+	if ctx.GOOS == "aix" && ctx.GOARCH == "ppc64" {
+		arArgs = []string{"-X64"}
+	}
+
+	files := StepFiles{
+		Inputs:  []string{archive},
+		Outputs: []string{newArchive},
+	}
+	if err := exec.Run(a, files, nil, "cp", archive, newArchive); err != nil {
+		return nil, err
+	}
+
+	files = StepFiles{
+		Inputs:  []string{newArchive},
+		Outputs: []string{newArchive},
+	}
+	if err := exec.Run(a, files, nil, tools.ar(), arArgs, "d", newArchive, "_cgo_flags"); err != nil {
+		return nil, err
+	}
+
+	data, err := r.ReadFile(d.Objdir + "_cgo_flags")
+	if err != nil {
+		return nil, err
+	}
+
+	var flags []string
+	const ldflagsPrefix = "_CGO_LDFLAGS="
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, ldflagsPrefix) {
+			flag := line[len(ldflagsPrefix):]
+			// Every _cgo_flags file has -g and -O2 in _CGO_LDFLAGS
+			// but they don't mean anything to the linker so filter
+			// them out.
+			if flag != "-g" && !strings.HasPrefix(flag, "-O") {
+				flags = append(flags, flag)
+			}
+		}
+	}
+
+	return flags, nil
+}
+
+// gccgoPkgpath returns the -fgo-pkgpath of a's package, "" for commands.
+func gccgoPkgpath(a Action) string {
+	if a.Package.Name == "main" {
+		return ""
+	}
+	return a.Package.ImportPath
+}
+
+var (
+	gccgoToSymbolFuncMu sync.Mutex
+	gccgoToSymbolFuncs  = make(map[string]func(string) string)
+)
+
+// gccgoCleanPkgpath returns the package path of a's package
+// as used in symbols by the gccgo compiler.
+func (tools gccgoToolchain) gccgoCleanPkgpath(a Action) (string, error) {
+	gccgoToSymbolFuncMu.Lock()
+	defer gccgoToSymbolFuncMu.Unlock()
+
+	compiler := tools.compiler()
+
+	fn, ok := gccgoToSymbolFuncs[compiler]
+	if !ok {
+		var err error
+		fn, err = gccgoToSymbolFunc(compiler)
+		if err != nil {
+			return "", err
+		}
+		gccgoToSymbolFuncs[compiler] = fn
+	}
+
+	return fn(gccgoPkgpath(a)), nil
+}
+
+// gccgoToSymbolFunc returns a function that may be used to convert a
+// package path into a string suitable for use as a symbol.
+// cmd is the gccgo compiler in use.
+// For example, this returns a function that converts "net/http"
+// into a string like "net..z2fhttp". The actual string varies for
+// different gccgo versions, which is why this returns a function
+// that does the conversion appropriate for the compiler in use.
+func gccgoToSymbolFunc(cmd string) (func(string) string, error) {
+	// To determine the scheme used by cmd, we compile a small
+	// file and examine the assembly code. Older versions of gccgo
+	// use a simple mangling scheme where there can be collisions
+	// between packages whose paths are different but mangle to
+	// the same string. More recent versions use a new mangler
+	// that avoids these collisions.
+	const filepat = "*_gccgo_manglechck.go"
+	f, err := ioutil.TempFile("", filepat)
+	if err != nil {
+		return nil, err
+	}
+	gofilename := f.Name()
+	f.Close()
+	defer os.Remove(gofilename)
+
+	if err := ioutil.WriteFile(gofilename, []byte(mangleCheckCode), 0644); err != nil {
+		return nil, err
+	}
+
+	command := exec.Command(cmd, "-S", "-o", "-", gofilename)
+	buf, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", cmd, err)
+	}
+
+	// Original mangling: go.l__ufer.Run
+	// Mangling v2: go.l..u00e4ufer.Run
+	// Mangling v3: go_0l_u00e4ufer.Run
+	if bytes.Contains(buf, []byte("go_0l_u00e4ufer.Run")) {
+		return toSymbolV3, nil
+	} else if bytes.Contains(buf, []byte("go.l..u00e4ufer.Run")) {
+		return toSymbolV2, nil
+	} else if bytes.Contains(buf, []byte("go.l__ufer.Run")) {
+		return toSymbolV1, nil
+	} else {
+		return nil, errors.New(cmd + ": unrecognized mangling scheme")
+	}
+}
+
+// mangleCheckCode is the package we compile to determine the mangling scheme.
+const mangleCheckCode = `
+package läufer
+func Run(x int) int {
+  return 1
+}
+`
+
+// toSymbolV1 converts a package path using the original mangling scheme.
+func toSymbolV1(ppath string) string {
+	clean := func(r rune) rune {
+		switch {
+		case 'A' <= r && r <= 'Z', 'a' <= r && r <= 'z',
+			'0' <= r && r <= '9':
+			return r
+		}
+		return '_'
+	}
+	return strings.Map(clean, ppath)
+}
+
+// toSymbolV2 converts a package path using the second mangling scheme.
+func toSymbolV2(ppath string) string {
+	var bsl strings.Builder
+	changed := false
+	for _, c := range ppath {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '_' {
+			bsl.WriteByte(byte(c))
+			continue
+		}
+		var enc string
+		switch {
+		case c == '.':
+			enc = ".x2e"
+		case c < 0x80:
+			enc = fmt.Sprintf("..z%02x", c)
+		case c < 0x10000:
+			enc = fmt.Sprintf("..u%04x", c)
+		default:
+			enc = fmt.Sprintf("..U%08x", c)
+		}
+		bsl.WriteString(enc)
+		changed = true
+	}
+	if !changed {
+		return ppath
+	}
+	return bsl.String()
+}
+
+// v3UnderscoreCodes maps from a character that supports an underscore
+// encoding to the underscore encoding character.
+var v3UnderscoreCodes = map[byte]byte{
+	'_': '_',
+	'.': '0',
+	'/': '1',
+	'*': '2',
+	',': '3',
+	'{': '4',
+	'}': '5',
+	'[': '6',
+	']': '7',
+	'(': '8',
+	')': '9',
+	'"': 'a',
+	' ': 'b',
+	';': 'c',
+}
+
+// toSymbolV3 converts a package path using the third mangling scheme.
+func toSymbolV3(ppath string) string {
+	var bsl strings.Builder
+	changed := false
+	for _, c := range ppath {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			bsl.WriteByte(byte(c))
+			continue
+		}
+
+		if c < 0x80 {
+			if u, ok := v3UnderscoreCodes[byte(c)]; ok {
+				bsl.WriteByte('_')
+				bsl.WriteByte(u)
+				changed = true
+				continue
+			}
+		}
+
+		var enc string
+		switch {
+		case c < 0x80:
+			enc = fmt.Sprintf("_x%02x", c)
+		case c < 0x10000:
+			enc = fmt.Sprintf("_u%04x", c)
+		default:
+			enc = fmt.Sprintf("_U%08x", c)
+		}
+		bsl.WriteString(enc)
+		changed = true
+	}
+	if !changed {
+		return ppath
+	}
+	return bsl.String()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGccgoToolchainPack(t *testing.T) {
+	tests := []struct {
+		ar string
+		op string
+	}{
+		{ar: "ar", op: "rcD"},
+		{ar: "false", op: "rc"},
+	}
+
+	a := Action{
+		Package: Package{Package: &build.Package{ImportPath: "example.com/p", Dir: "/src/p"}},
+		Objdir:  "$WORK/b001/",
+	}
+
+	defer os.Setenv("AR", os.Getenv("AR"))
+	for _, test := range tests {
+		if _, err := exec.LookPath(test.ar); err != nil {
+			t.Logf("skipping %s: %v", test.ar, err)
+			continue
+		}
+		os.Setenv("AR", test.ar)
+
+		var exec planExecutor
+		if err := (gccgoToolchain{}).Pack(Context{}, &exec, a, "_pkg_.a", []string{"_go_.o", "_x001_.o"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Support for the D modifier is probed once, rather than tried by the build.
+		if len(exec.steps) != 1 {
+			t.Fatalf("AR=%s: %d steps, want 1", test.ar, len(exec.steps))
+		}
+		want := []string{test.ar, test.op, "$WORK/b001/_pkg_.a", "$WORK/b001/_go_.o", "$WORK/b001/_x001_.o"}
+		if got := exec.steps[0].Args; strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("AR=%s: pack runs %q, want %q", test.ar, got, want)
+		}
+	}
+}
+
+func TestGccgoToolchainRecordedCgoLink(t *testing.T) {
+	d := &Action{
+		Package: Package{Package: &build.Package{ImportPath: "example.com/c", Dir: "/src/c", CgoFiles: []string{"c.go"}}},
+		Objdir:  "$WORK/b001/",
+	}
+	a := Action{
+		Package: Package{Package: &build.Package{ImportPath: "example.com/p", Name: "main", Dir: "/src/p"}},
+		Objdir:  "$WORK/b002/",
+		Deps:    []*Action{d},
+	}
+
+	// The LDFLAGS of cgo packages are read from their archive by the link,
+	// which recorded builds cannot do.
+	var exec planExecutor
+	err := (gccgoToolchain{}).Ld(Context{}, &exec, a, "/out/p", "", a.archive())
+	if err == nil || !strings.Contains(err.Error(), "example.com/c") {
+		t.Errorf("error %v, want an error about example.com/c", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TrimpathMode selects how the paths of source files are recorded
+// in compiled packages and executables.
+type TrimpathMode int
+
+const (
+	// TrimpathModule records source files relative to their module
+	// (module@version for packages in the module cache) or import path,
+	// as go build -trimpath does. The executable does not record GOROOT.
+	TrimpathModule TrimpathMode = iota
+
+	// TrimpathOff records the real paths of source files, eg. for debugging.
+	TrimpathOff
+
+	// TrimpathCustom rewrites the directories of Context.TrimpathMap,
+	// and records any other path as is.
+	TrimpathCustom
+)
+
+// pathRewrite rewrites the directory From, and the paths below it, to To.
+type pathRewrite struct {
+	From, To string
+}
+
+// pathRewrites returns the rewrites of the source paths of the action,
+// most specific first.
+func (a *Action) pathRewrites(ctx Context) []pathRewrite {
+	switch ctx.Trimpath {
+	case TrimpathOff:
+		return nil
+
+	case TrimpathCustom:
+		var rewrites []pathRewrite
+		for from, to := range ctx.TrimpathMap {
+			rewrites = append(rewrites, pathRewrite{filepath.Clean(from), to})
+		}
+
+		// The compiler applies the first matching rewrite.
+		sort.Slice(rewrites, func(i, j int) bool {
+			if len(rewrites[i].From) != len(rewrites[j].From) {
+				return len(rewrites[i].From) > len(rewrites[j].From)
+			}
+			return rewrites[i].From < rewrites[j].From
+		})
+
+		return rewrites
+	}
+
+	var rewriteDir string
+	if m := a.Package.ModulePath; m != "" {
+		// Vendored packages are rewritten to their path in their module.
+		importPath := a.Package.ImportPath
+		if !strings.HasPrefix(importPath, m) {
+			importPath = unvendoredPath(importPath)
+		}
+
+		if v := a.Package.ModuleVersion; v != "" {
+			rewriteDir = m + "@" + v + strings.TrimPrefix(importPath, m)
+		} else {
+			rewriteDir = m + strings.TrimPrefix(importPath, m)
+		}
+	} else {
+		rewriteDir = a.Package.ImportPath
+	}
+
+	return []pathRewrite{{a.Package.Dir, rewriteDir}}
+}
+
+// trimmedGoroot returns the GOROOT recorded in executables,
+// and whether it differs from ctx.GOROOT.
+// If paths are trimmed relative to modules, trimmed is recorded.
+func (ctx Context) trimmedGoroot(trimmed string) (string, bool) {
+	switch ctx.Trimpath {
+	case TrimpathOff:
+		return ctx.GOROOT, false
+
+	case TrimpathCustom:
+		goroot := filepath.Clean(ctx.GOROOT)
+		for from, to := range ctx.TrimpathMap {
+			if filepath.Clean(from) == goroot {
+				return to, true
+			}
+		}
+
+		return ctx.GOROOT, false
+	}
+
+	return trimmed, true
+}
+
+// prefixMapFlags returns the flags applying the rewrites of the action's
+// source paths to the debug information compiler writes.
+func (c cToolchain) prefixMapFlags(compiler []string, a Action) []string {
+	if c.ctx.Trimpath == TrimpathOff {
+		return nil
+	}
+
+	flag := "-ffile-prefix-map"
+	if !c.supportsFlag(compiler, "-ffile-prefix-map=a=b") {
+		if !c.supportsFlag(compiler, "-fdebug-prefix-map=a=b") {
+			return nil
+		}
+		flag = "-fdebug-prefix-map"
+	}
+
+	// Strip the object directory entirely.
+	flags := []string{flag + "=" + a.Objdir + "="}
+
+	// Unlike the Go compiler, the C compiler applies the last matching rewrite.
+	rewrites := a.pathRewrites(c.ctx)
+	for i := len(rewrites) - 1; i >= 0; i-- {
+		flags = append(flags, flag+"="+rewrites[i].From+"="+rewrites[i].To)
+	}
+
+	return flags
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"go/build"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestPathRewrites(t *testing.T) {
+	pkg := func(importPath, dir, modulePath, moduleVersion string) Action {
+		return Action{
+			Package: Package{
+				Package:       &build.Package{ImportPath: importPath, Dir: dir},
+				ModulePath:    modulePath,
+				ModuleVersion: moduleVersion,
+			},
+			Objdir: "$WORK/b001/",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		ctx      Context
+		a        Action
+		rewrites []pathRewrite
+	}{
+		{
+			name:     "main module",
+			a:        pkg("example.com/m/p", "/src/m/p", "example.com/m", ""),
+			rewrites: []pathRewrite{{"/src/m/p", "example.com/m/p"}},
+		},
+		{
+			name:     "module cache",
+			a:        pkg("example.com/dep/p", "/mod/example.com/dep@v1.2.3/p", "example.com/dep", "v1.2.3"),
+			rewrites: []pathRewrite{{"/mod/example.com/dep@v1.2.3/p", "example.com/dep@v1.2.3/p"}},
+		},
+		{
+			name:     "vendored",
+			a:        pkg("example.com/m/vendor/example.com/dep/p", "/src/m/vendor/example.com/dep/p", "example.com/dep", "v1.2.3"),
+			rewrites: []pathRewrite{{"/src/m/vendor/example.com/dep/p", "example.com/dep@v1.2.3/p"}},
+		},
+		{
+			name:     "GOPATH",
+			a:        pkg("example.com/p", "/gopath/src/example.com/p", "", ""),
+			rewrites: []pathRewrite{{"/gopath/src/example.com/p", "example.com/p"}},
+		},
+		{
+			name: "off",
+			ctx:  Context{Trimpath: TrimpathOff},
+			a:    pkg("example.com/m/p", "/src/m/p", "example.com/m", ""),
+		},
+		{
+			name: "custom",
+			ctx: Context{Trimpath: TrimpathCustom, TrimpathMap: map[string]string{
+				"/src/":      "src",
+				"/src/m/p":   "p",
+				"/other/dir": "other",
+			}},
+			a: pkg("example.com/m/p", "/src/m/p", "example.com/m", ""),
+			rewrites: []pathRewrite{
+				{"/other/dir", "other"},
+				{"/src/m/p", "p"},
+				{"/src", "src"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.a.pathRewrites(test.ctx); !reflect.DeepEqual(got, test.rewrites) {
+			t.Errorf("%s: pathRewrites() = %v, want %v", test.name, got, test.rewrites)
+		}
+	}
+}
+
+func TestPrefixMapFlags(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip(err)
+	}
+
+	a := Action{
+		Package: Package{
+			Package:    &build.Package{ImportPath: "example.com/m/p", Dir: "/src/m/p"},
+			ModulePath: "example.com/m",
+		},
+		Objdir: "$WORK/b001/",
+	}
+
+	tests := []struct {
+		name  string
+		ctx   Context
+		flags []string
+	}{
+		{
+			name:  "module",
+			flags: []string{"-ffile-prefix-map=$WORK/b001/=", "-ffile-prefix-map=/src/m/p=example.com/m/p"},
+		},
+		{
+			name: "off",
+			ctx:  Context{Trimpath: TrimpathOff},
+		},
+		{
+			// The C compiler applies the last matching rewrite.
+			name: "custom",
+			ctx: Context{Trimpath: TrimpathCustom, TrimpathMap: map[string]string{
+				"/src":     "src",
+				"/src/m/p": "p",
+			}},
+			flags: []string{"-ffile-prefix-map=$WORK/b001/=", "-ffile-prefix-map=/src=src", "-ffile-prefix-map=/src/m/p=p"},
+		},
+	}
+
+	for _, test := range tests {
+		c := newCToolchain(test.ctx)
+		if got := c.prefixMapFlags([]string{"gcc"}, a); !reflect.DeepEqual(got, test.flags) {
+			t.Errorf("%s: prefixMapFlags() = %q, want %q", test.name, got, test.flags)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// loadVendor reads the vendor/modules.txt file of the main module if the
+// build uses its vendor directory, and returns the modules providing the
+// vendored packages by package path.
+//
+// Like the go command, mod "vendor" requires the vendor directory and mod
+// "mod" ignores it. Otherwise it is used if the main module declares Go 1.14
+// or later. Workspaces always import from the module cache.
+func (ml *modLoader) loadVendor(mod string) (map[string]*module, error) {
+	if ml.work != nil || len(ml.main) != 1 || mod == "mod" {
+		return nil, nil
+	}
+	main := ml.main[0]
+
+	file := filepath.Join(main.Dir, "vendor", "modules.txt")
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && mod != "vendor" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if mod == "" {
+		minor, err := parseGoMinor("go" + main.GoVersion)
+		if err != nil || minor < 14 {
+			return nil, nil
+		}
+	}
+
+	return parseModulesTxt(file, data)
+}
+
+// parseModulesTxt parses a vendor/modules.txt file. It lists every vendored
+// module, its annotations and its packages:
+//
+//	# golang.org/x/sys v0.1.0
+//	## explicit; go 1.17
+//	golang.org/x/sys/unix
+func parseModulesTxt(file string, data []byte) (map[string]*module, error) {
+	pkgs := make(map[string]*module)
+
+	var m *module
+	for i, line := range strings.Split(string(data), "\n") {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			if m == nil {
+				continue
+			}
+
+			for _, annotation := range strings.Split(line[len("## "):], ";") {
+				if v := strings.TrimSpace(annotation); strings.HasPrefix(v, "go ") {
+					m.GoVersion = strings.TrimSpace(v[len("go "):])
+				}
+			}
+
+		case strings.HasPrefix(line, "# "):
+			// A module, maybe followed by its replacement.
+			// Replacements of modules not providing packages have no version.
+			fields := strings.Fields(line[len("# "):])
+			if len(fields) < 2 || fields[1] == "=>" {
+				m = nil
+				continue
+			}
+
+			m = &module{Path: fields[0], Version: fields[1]}
+
+		case strings.TrimSpace(line) == "":
+
+		default:
+			if m == nil {
+				return nil, fmt.Errorf("%s:%d: package %s without module", file, i+1, line)
+			}
+
+			pkgs[strings.TrimSpace(line)] = m
+		}
+	}
+
+	return pkgs, nil
+}
+
+// resolveVendored returns the directory, import path and module of the
+// package at path, imported from the main module or its vendor directory.
+//
+// Vendored packages are imported as a package of the main module,
+// eg. example.com/app/vendor/golang.org/x/sys/unix.
+func (ml *modLoader) resolveVendored(path string) (dir string, importPath string, m *module, err error) {
+	main := ml.main[0]
+
+	if path == main.Path || strings.HasPrefix(path, main.Path+"/") {
+		dir = filepath.Join(main.Dir, filepath.FromSlash(strings.TrimPrefix(path, main.Path)))
+		if hasGoFiles(dir) {
+			return dir, path, main, nil
+		}
+	}
+
+	m, ok := ml.vendor[path]
+	if !ok {
+		return "", "", nil, fmt.Errorf("package %s is not in vendor/modules.txt", path)
+	}
+
+	dir = filepath.Join(main.Dir, "vendor", filepath.FromSlash(path))
+	if !isDir(dir) {
+		return "", "", nil, fmt.Errorf("package %s is not in the vendor directory (%s)", path, dir)
+	}
+
+	return dir, pathpkg.Join(main.Path, "vendor", path), m, nil
+}
+
+// unvendoredPath returns the import path a vendored package is vendored for,
+// eg. golang.org/x/sys/unix for example.com/app/vendor/golang.org/x/sys/unix.
+func unvendoredPath(path string) string {
+	if strings.HasPrefix(path, "vendor/") {
+		return path[len("vendor/"):]
+	}
+
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		return path[i+len("/vendor/"):]
+	}
+
+	return path
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModulesTxt(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		pkgs map[string]module
+		err  string
+	}{
+		{
+			name: "modules",
+			data: `# example.com/a v1.0.0
+## explicit; go 1.17
+example.com/a
+example.com/a/sub
+# example.com/b v1.1.0
+## explicit
+example.com/b
+`,
+			pkgs: map[string]module{
+				"example.com/a":     {Path: "example.com/a", Version: "v1.0.0", GoVersion: "1.17"},
+				"example.com/a/sub": {Path: "example.com/a", Version: "v1.0.0", GoVersion: "1.17"},
+				"example.com/b":     {Path: "example.com/b", Version: "v1.1.0"},
+			},
+		},
+		{
+			name: "replacements",
+			data: `# example.com/a v1.0.0 => ./a
+## explicit; go 1.18
+example.com/a
+# example.com/b v1.1.0 => example.com/c v1.2.0
+example.com/b
+# example.com/d => ../d
+## explicit
+# example.com/e v1.0.0 => ./e
+`,
+			pkgs: map[string]module{
+				"example.com/a": {Path: "example.com/a", Version: "v1.0.0", GoVersion: "1.18"},
+				"example.com/b": {Path: "example.com/b", Version: "v1.1.0"},
+			},
+		},
+		{
+			name: "package without module",
+			data: "# example.com/d => ../d\nexample.com/d\n",
+			err:  "modules.txt:2: package example.com/d without module",
+		},
+	}
+
+	for _, test := range tests {
+		pkgs, err := parseModulesTxt("modules.txt", []byte(test.data))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		got := make(map[string]module)
+		for path, m := range pkgs {
+			got[path] = *m
+		}
+		if !reflect.DeepEqual(got, test.pkgs) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.pkgs)
+		}
+	}
+}
+
+func TestResolveVendored(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                           "module example.com/m\n\ngo 1.17\n\nrequire example.com/a v1.0.0\n",
+		"main.go":                          "package main\n",
+		"vendor/modules.txt":               "# example.com/a v1.0.0\n## explicit; go 1.16\nexample.com/a\n",
+		"vendor/example.com/a/a.go":        "package a\n",
+		"vendor/example.com/unlisted/u.go": "package unlisted\n",
+	})
+
+	// The module cache is empty: vendored packages do not need it.
+	ml, bctx := newTestModLoader(t, dir, t.TempDir())
+
+	checkResolve(t, ml, bctx, dir, []resolveTest{
+		{path: "example.com/m", dir: dir, importPath: "example.com/m", module: "example.com/m"},
+		{path: "example.com/a", dir: filepath.Join(dir, "vendor", "example.com", "a"), importPath: "example.com/m/vendor/example.com/a", module: "example.com/a"},
+		{path: "fmt", dir: filepath.Join(bctx.GOROOT, "src", "fmt")},
+		{path: "example.com/unlisted", err: "package example.com/unlisted is not in vendor/modules.txt"},
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// goVersion is the version of a Go toolchain.
+type goVersion struct {
+	// Name is the version reported by the toolchain, eg. "go1.21.3".
+	// It matches runtime.Version() of the toolchain's programs.
+	Name string
+
+	// Minor is the minor version of the Go 1 release, eg. 21.
+	Minor int
+}
+
+// atLeast reports whether v is Go 1.minor or newer.
+func (v goVersion) atLeast(minor int) bool {
+	return v.Minor >= minor
+}
+
+type goVersionResult struct {
+	v   goVersion
+	err error
+}
+
+var (
+	goVersionsMu sync.Mutex
+	goVersions   = make(map[string]*goVersionResult)
+)
+
+// goVersion returns the version of the Go toolchain at ctx.GoTool, probed once per GoTool.
+func (ctx Context) goVersion() (goVersion, error) {
+	goVersionsMu.Lock()
+	defer goVersionsMu.Unlock()
+
+	r, ok := goVersions[ctx.GoTool]
+	if !ok {
+		v, err := probeGoVersion(ctx.GoTool)
+		r = &goVersionResult{v, err}
+		goVersions[ctx.GoTool] = r
+	}
+
+	return r.v, r.err
+}
+
+// probeGoVersion runs the go command to determine its version.
+func probeGoVersion(gotool string) (goVersion, error) {
+	// go env GOVERSION reports the exact version since Go 1.16.
+	out, err := exec.Command(gotool, "env", "GOVERSION").Output()
+	name := string(bytes.TrimSpace(out))
+
+	if err != nil || name == "" {
+		// Older releases only report it with go version:
+		// "go version go1.15.15 linux/amd64".
+		out, err = exec.Command(gotool, "version").Output()
+		if err != nil {
+			return goVersion{}, fmt.Errorf("%s version: %v", gotool, err)
+		}
+
+		fields := strings.Fields(string(out))
+		if len(fields) < 3 || fields[0] != "go" || fields[1] != "version" {
+			return goVersion{}, fmt.Errorf("%s version: unexpected output %q", gotool, out)
+		}
+		name = fields[2]
+	}
+
+	minor, err := parseGoMinor(name)
+	if err != nil {
+		return goVersion{}, fmt.Errorf("%s: %v", gotool, err)
+	}
+
+	return goVersion{Name: name, Minor: minor}, nil
+}
+
+// parseGoMinor returns the minor version of a Go 1 version name,
+// eg. 21 for "go1.21.3", "go1.21rc1" or "devel go1.21-abcdef Tue Jan 1 ...".
+func parseGoMinor(name string) (int, error) {
+	s := strings.TrimPrefix(name, "devel ")
+
+	if !strings.HasPrefix(s, "go1.") {
+		return 0, fmt.Errorf("unrecognized Go version %q", name)
+	}
+	s = s[len("go1."):]
+
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+
+	minor, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized Go version %q", name)
+	}
+
+	return minor, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParseGoMinor(t *testing.T) {
+	tests := []struct {
+		name  string
+		minor int
+		err   bool
+	}{
+		{name: "go1.21.3", minor: 21},
+		{name: "go1.16", minor: 16},
+		{name: "go1.21rc1", minor: 21},
+		{name: "go1.9beta2", minor: 9},
+		{name: "go1.22.0 X:nocoverageredesign", minor: 22},
+		{name: "devel go1.21-abcdef Tue Jan 1 00:00:00 2023 +0000", minor: 21},
+		{name: "go1", err: true},
+		{name: "go1.", err: true},
+		{name: "go2.0", err: true},
+		{name: "devel +abcdef Tue Jan 1 00:00:00 2019 +0000", err: true},
+		{name: "", err: true},
+	}
+
+	for _, test := range tests {
+		minor, err := parseGoMinor(test.name)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseGoMinor(%q) = %d, want an error", test.name, minor)
+			}
+			continue
+		}
+
+		if err != nil || minor != test.minor {
+			t.Errorf("parseGoMinor(%q) = %d, %v, want %d", test.name, minor, err, test.minor)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// workFile is a parsed go.work file.
+type workFile struct {
+	// Dir is the directory of the go.work file.
+	// The directories of use and replace directives are relative to it.
+	Dir string
+
+	Go      string
+	Use     []string
+	Replace []modReplace
+}
+
+// parseWorkFile parses the go.work file named file.
+func parseWorkFile(file string, data []byte) (*workFile, error) {
+	lines, err := modLines(file, data)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &workFile{Dir: filepath.Dir(file)}
+
+	for _, l := range lines {
+		switch l.verb() {
+		case "go":
+			if len(l.args) != 1 {
+				return nil, l.errorf("usage: go 1.23")
+			}
+			f.Go = l.args[0]
+
+		case "use":
+			if len(l.args) != 1 {
+				return nil, l.errorf("usage: use local/dir")
+			}
+			f.Use = append(f.Use, l.args[0])
+
+		case "replace":
+			r, err := parseReplace(l)
+			if err != nil {
+				return nil, err
+			}
+			f.Replace = append(f.Replace, r)
+		}
+	}
+
+	return f, nil
+}
+
+// goworkFile returns the go.work file configured for the build:
+// "off", a file name, or empty to look for one.
+func goworkFile(ctx Context) string {
+	if ctx.GOWORK != "" {
+		return ctx.GOWORK
+	}
+
+	return os.Getenv("GOWORK")
+}
+
+// findWorkFile returns the go.work file of a build run in the directory dir,
+// or "" if it is not built in a workspace.
+func (ml *modLoader) findWorkFile(dir string) (string, error) {
+	switch ml.gowork {
+	case "off":
+		return "", nil
+
+	case "":
+		for {
+			file := filepath.Join(dir, "go.work")
+			if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+				return file, nil
+			}
+
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return "", nil
+			}
+			dir = parent
+		}
+	}
+
+	if !filepath.IsAbs(ml.gowork) {
+		return "", fmt.Errorf("GOWORK must be an absolute path: %s", ml.gowork)
+	}
+
+	return ml.gowork, nil
+}
+
+// loadWorkspace reads the go.work file and makes the modules it uses
+// the main modules of the build.
+func (ml *modLoader) loadWorkspace(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	ml.work, err = parseWorkFile(file, data)
+	if err != nil {
+		return err
+	}
+
+	for _, use := range ml.work.Use {
+		dir := filepath.FromSlash(use)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(ml.work.Dir, dir)
+		}
+
+		m, err := ml.load(filepath.Clean(dir), "", "")
+		if err != nil {
+			return err
+		}
+
+		if ml.isMain(m.Path) {
+			return fmt.Errorf("%s: module %s appears multiple times in workspace", file, m.Path)
+		}
+		ml.main = append(ml.main, m)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWorkFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		file *workFile
+		err  string
+	}{
+		{
+			name: "directives",
+			data: `go 1.18
+
+use (
+	./app
+	"./lib"
+)
+use /abs/other
+
+replace example.com/x v1.0.0 => ./x
+`,
+			file: &workFile{
+				Dir:     "/work",
+				Go:      "1.18",
+				Use:     []string{"./app", "./lib", "/abs/other"},
+				Replace: []modReplace{{Old: modVersion{"example.com/x", "v1.0.0"}, New: modVersion{Path: "./x"}}},
+			},
+		},
+		{
+			name: "use without directory",
+			data: "go 1.18\nuse\n",
+			err:  "go.work:2: usage: use local/dir",
+		},
+		{
+			name: "invalid replace",
+			data: "go 1.18\nreplace example.com/x => example.com/y\n",
+			err:  "go.work:2: replacement module without version must be directory path",
+		},
+	}
+
+	for _, test := range tests {
+		f, err := parseWorkFile("/work/go.work", []byte(test.data))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(f, test.file) {
+			t.Errorf("%s: got %+v, want %+v", test.name, f, test.file)
+		}
+	}
+}
+
+func TestFindWorkFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.work":        "go 1.18\n",
+		"app/go.mod":     "module example.com/app\n",
+		"app/sub/sub.go": "package sub\n",
+	})
+	file := filepath.Join(dir, "go.work")
+
+	tests := []struct {
+		gowork string
+		file   string
+		err    string
+	}{
+		{gowork: "", file: file},
+		{gowork: "off", file: ""},
+		{gowork: "/other/go.work", file: "/other/go.work"},
+		{gowork: "go.work", err: "GOWORK must be an absolute path"},
+	}
+
+	for _, test := range tests {
+		ml := &modLoader{gowork: test.gowork}
+		got, err := ml.findWorkFile(filepath.Join(dir, "app", "sub"))
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("GOWORK=%q: error %v, want %q", test.gowork, err, test.err)
+			}
+
+		case err != nil:
+			t.Errorf("GOWORK=%q: %v", test.gowork, err)
+
+		case got != test.file:
+			t.Errorf("GOWORK=%q: go.work file %q, want %q", test.gowork, got, test.file)
+		}
+	}
+}
+
+func TestResolveWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.work": "go 1.18\n\nuse (\n\t./app\n\t./lib\n)\n\nreplace example.com/x v1.0.0 => ./x\n",
+
+		"app/go.mod":  "module example.com/app\n\ngo 1.18\n\nrequire example.com/x v1.0.0\n",
+		"app/main.go": "package main\n",
+		// Workspaces ignore the vendor directories of their modules.
+		"app/vendor/modules.txt":        "# example.com/x v1.0.0\n## explicit\nexample.com/x\n",
+		"app/vendor/example.com/x/x.go": "package x\n",
+
+		"lib/go.mod":     "module example.com/lib\n\ngo 1.18\n",
+		"lib/lib.go":     "package lib\n",
+		"lib/sub/sub.go": "package sub\n",
+
+		"x/go.mod": "module example.com/x\n\ngo 1.16\n",
+		"x/x.go":   "package x\n",
+	})
+
+	bctx := build.Default
+	ctx := Context{GOMODCACHE: t.TempDir(), GOWORK: filepath.Join(dir, "go.work")}
+	ml := newModLoader(ctx, &bctx)
+	app := filepath.Join(dir, "app")
+	if err := ml.loadMain(app); err != nil {
+		t.Fatal(err)
+	}
+
+	checkResolve(t, ml, &bctx, app, []resolveTest{
+		{path: "example.com/app", dir: app, importPath: "example.com/app", module: "example.com/app"},
+		{path: "example.com/lib/sub", dir: filepath.Join(dir, "lib", "sub"), importPath: "example.com/lib/sub", module: "example.com/lib"},
+		{path: "../lib", dir: filepath.Join(dir, "lib"), importPath: "example.com/lib", module: "example.com/lib"},
+		{path: "example.com/x", dir: filepath.Join(dir, "x"), importPath: "example.com/x", module: "example.com/x"},
+		{path: "example.com/y", err: "no required module provides package example.com/y"},
+		{path: "../x", err: "outside modules listed in go.work"},
+	})
+
+	// Modules may be used only once.
+	writeFiles(t, dir, map[string]string{"go.work": "go 1.18\n\nuse ./app\nuse ./app/\n"})
+	ml = newModLoader(ctx, &bctx)
+	if err := ml.loadMain(app); err == nil || !strings.Contains(err.Error(), "module example.com/app appears multiple times in workspace") {
+		t.Errorf("duplicate use: error %v", err)
+	}
+}